@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuditMaxSizeMB = 10
+	defaultAuditMaxFiles  = 5
+	redactedValue         = "[REDACTED]"
+)
+
+// sensitiveArgumentWords mark an argument as secret when they appear as a word of its name, e.g. "pairing_code"
+var sensitiveArgumentWords = map[string]bool{
+	"token":      true,
+	"password":   true,
+	"passwd":     true,
+	"secret":     true,
+	"auth":       true,
+	"pin":        true,
+	"passphrase": true,
+	"code":       true,
+	"credential": true,
+	"key":        true,
+}
+
+// AuditConfig enables the JSONL audit log of device actions; an empty path disables it
+type AuditConfig struct {
+	Path      string  `json:"path,omitempty"`
+	MaxSizeMB float64 `json:"max_size_mb,omitempty"`
+	MaxFiles  int     `json:"max_files,omitempty"`
+}
+
+// AuditEntry is one line of the audit log, describing a single tools/call
+type AuditEntry struct {
+	Time       string                 `json:"time"`
+	Client     ClientInfo             `json:"client"`
+	Tool       string                 `json:"tool"`
+	Arguments  map[string]interface{} `json:"arguments,omitempty"`
+	Device     string                 `json:"device,omitempty"`
+	Commands   []AuditCommand         `json:"commands,omitempty"`
+	Outcome    string                 `json:"outcome"`
+	Error      string                 `json:"error,omitempty"`
+	DurationMS int64                  `json:"duration_ms"`
+}
+
+// AuditCommand is a process started or an emulator console command sent on behalf of a tool call;
+// ExitCode is absent if the process never ran or is still running
+type AuditCommand struct {
+	Argv     []string `json:"argv,omitempty"`
+	Console  string   `json:"console,omitempty"`
+	ExitCode *int     `json:"exit_code,omitempty"`
+}
+
+// auditedCommand is a command recorded during a tool call; cmd is set when its exit code can be read at the end
+type auditedCommand struct {
+	cmd     *exec.Cmd
+	argv    []string
+	console string
+}
+
+// auditRecord collects what a tool call does until it is written to the audit log
+type auditRecord struct {
+	entry   AuditEntry
+	started time.Time
+	secrets []string
+
+	// commands is appended to by the goroutines working on the call, such as fetchDeviceDetails workers
+	mutex    sync.Mutex
+	commands []auditedCommand
+}
+
+// auditContextKey carries a tool call's auditRecord in the context passed down to the commands it runs
+type auditContextKey struct{}
+
+// clientInfo identifies the connected client, as reported in initialize
+var clientInfo ClientInfo
+
+var auditLogMutex sync.Mutex
+
+// Validate reports an audit log path or limit that cannot be used
+func (c *AuditConfig) Validate() error {
+	if c.Path != "" {
+		info, err := os.Stat(filepath.Dir(c.Path))
+		if err != nil {
+			return fmt.Errorf("audit_log.path: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("audit_log.path: %s is not a directory", filepath.Dir(c.Path))
+		}
+	}
+	if c.MaxSizeMB < 0 {
+		return fmt.Errorf("audit_log.max_size_mb must not be negative")
+	}
+	if c.MaxFiles < 0 {
+		return fmt.Errorf("audit_log.max_files must not be negative")
+	}
+	return nil
+}
+
+// beginAudit starts recording a tool call, returning nil when the audit log is disabled
+func beginAudit(tool string, arguments map[string]interface{}) *auditRecord {
+	if currentConfig().AuditLog.Path == "" {
+		return nil
+	}
+
+	record := &auditRecord{
+		entry: AuditEntry{
+			Client:  clientInfo,
+			Tool:    tool,
+			Outcome: "ok",
+		},
+		started: time.Now(),
+	}
+	record.entry.Arguments = redactArguments(arguments, &record.secrets)
+	return record
+}
+
+// withAudit returns a context whose commands are recorded in r; a nil record leaves ctx unchanged
+func withAudit(ctx context.Context, r *auditRecord) context.Context {
+	if r == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, auditContextKey{}, r)
+}
+
+// recordAuditCommand remembers an adb command built for the tool call audited in ctx, if any
+func recordAuditCommand(ctx context.Context, cmd *exec.Cmd) {
+	recordAudit(ctx, auditedCommand{cmd: cmd, argv: cmd.Args})
+}
+
+// recordAuditProcess remembers the argv of a process that outlives the tool call, such as an emulator;
+// its exit code is not recorded because another goroutine waits for it
+func recordAuditProcess(ctx context.Context, cmd *exec.Cmd) {
+	recordAudit(ctx, auditedCommand{argv: append([]string{}, cmd.Args...)})
+}
+
+// recordAuditConsole remembers a command sent to an emulator console
+func recordAuditConsole(ctx context.Context, command string) {
+	recordAudit(ctx, auditedCommand{console: command})
+}
+
+func recordAudit(ctx context.Context, command auditedCommand) {
+	r, _ := ctx.Value(auditContextKey{}).(*auditRecord)
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.commands = append(r.commands, command)
+}
+
+func (r *auditRecord) setDevice(device string) {
+	if r != nil {
+		r.entry.Device = device
+	}
+}
+
+// fail records why the call did not succeed; outcome is "error", "blocked" or "invalid_params"
+func (r *auditRecord) fail(outcome string, err error) {
+	if r != nil {
+		r.entry.Outcome = outcome
+		r.entry.Error = r.redact(err.Error())
+	}
+}
+
+// end writes the record if the call resolved a device or ran adb
+func (r *auditRecord) end() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	commands := r.commands
+	r.mutex.Unlock()
+
+	for _, recorded := range commands {
+		command := AuditCommand{Console: r.redact(recorded.console)}
+		for _, arg := range redactArgv(recorded.argv) {
+			command.Argv = append(command.Argv, r.redact(arg))
+		}
+		if recorded.cmd != nil && recorded.cmd.ProcessState != nil {
+			exitCode := recorded.cmd.ProcessState.ExitCode()
+			command.ExitCode = &exitCode
+		}
+		r.entry.Commands = append(r.entry.Commands, command)
+	}
+
+	if r.entry.Device == "" && len(r.entry.Commands) == 0 {
+		return
+	}
+
+	r.entry.Time = r.started.UTC().Format(time.RFC3339Nano)
+	r.entry.DurationMS = time.Since(r.started).Milliseconds()
+	if err := writeAuditEntry(currentConfig().AuditLog, r.entry); err != nil {
+		logMessage("error", "audit", "Failed to write audit log: %v", err)
+	}
+}
+
+// redact replaces secret argument values wherever they appear in text
+func (r *auditRecord) redact(text string) string {
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, redactedValue)
+	}
+	return text
+}
+
+// redactArgv copies argv with the secrets adb takes as positional arguments replaced, such as the code of
+// `adb pair <address> <code>`; traces and transcripts use it too, since they do not know the call's arguments
+func redactArgv(argv []string) []string {
+	redacted := append([]string(nil), argv...)
+	for i, arg := range redacted {
+		if arg == "pair" && i+2 < len(redacted) {
+			redacted[i+2] = redactedValue
+			break
+		}
+	}
+	return redacted
+}
+
+// redactArguments copies arguments, replacing the values of secret-looking keys and collecting them into secrets
+func redactArguments(arguments map[string]interface{}, secrets *[]string) map[string]interface{} {
+	if len(arguments) == 0 {
+		return nil
+	}
+
+	redacted := make(map[string]interface{}, len(arguments))
+	for key, value := range arguments {
+		switch {
+		case isSensitiveArgument(key):
+			if text := fmt.Sprint(value); text != "" {
+				*secrets = append(*secrets, text)
+			}
+			redacted[key] = redactedValue
+		default:
+			if nested, ok := value.(map[string]interface{}); ok {
+				value = redactArguments(nested, secrets)
+			}
+			redacted[key] = value
+		}
+	}
+	return redacted
+}
+
+func isSensitiveArgument(name string) bool {
+	for _, word := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == '_' || r == '-' || r == '.'
+	}) {
+		if sensitiveArgumentWords[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAuditEntry appends entry to the audit log, rotating it first if it would exceed the size limit
+func writeAuditEntry(config AuditConfig, entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	auditLogMutex.Lock()
+	defer auditLogMutex.Unlock()
+
+	maxSize := int64(config.MaxSizeMB * 1024 * 1024)
+	if maxSize == 0 {
+		maxSize = defaultAuditMaxSizeMB * 1024 * 1024
+	}
+	if info, err := os.Stat(config.Path); err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > maxSize {
+		if err := rotateAuditLog(config.Path, config.MaxFiles); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(line)
+	return err
+}
+
+// rotateAuditLog shifts path to path.1, path.1 to path.2 and so on, dropping the oldest file
+func rotateAuditLog(path string, maxFiles int) error {
+	if maxFiles == 0 {
+		maxFiles = defaultAuditMaxFiles
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", path, maxFiles))
+	for i := maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(path, path+".1")
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readAuditLog(t *testing.T, path string) []AuditEntry {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	useConfig(t, &Config{AuditLog: AuditConfig{Path: path}})
+
+	registry := NewToolRegistry()
+	registry.Register(ToolDefinition{
+		Name: "test_tool",
+		InputSchema: map[string]interface{}{
+			"properties": map[string]interface{}{
+				"auth_token": map[string]interface{}{"type": "string"},
+				"keycode":    map[string]interface{}{"type": "integer"},
+			},
+		},
+		Device: DeviceRequired,
+		Handler: func(call *ToolCall) (ToolsCallResult, error) {
+			output, err := adbCommand(call.Context(), "-s", call.Device, "shell", "getprop", getStringArgument(call.Arguments, "auth_token")).Output()
+			if err != nil {
+				return ToolsCallResult{}, err
+			}
+			return textResult(string(output)), nil
+		},
+	})
+
+	originalSendResponse := sendResponse
+	sendResponse = func(resp JSONRPCResponse) {}
+	originalExecCommand := execCommand
+	execCommand = helperCommand
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) { return file, nil }
+	originalClientInfo := clientInfo
+	clientInfo = ClientInfo{Name: "test-client", Version: "1.2.3"}
+	defer func() {
+		sendResponse = originalSendResponse
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+		clientInfo = originalClientInfo
+	}()
+
+	registry.Call(JSONRPCRequest{ID: 1}, ToolsCallParams{
+		Name:      "test_tool",
+		Arguments: map[string]interface{}{"device": "emulator-5554", "auth_token": "s3cr3t", "keycode": float64(66)},
+	})
+	useConfig(t, &Config{AuditLog: AuditConfig{Path: path}, Policy: PolicyConfig{DeniedDevices: []string{"emulator-5554"}}})
+	registry.Call(JSONRPCRequest{ID: 2}, ToolsCallParams{
+		Name:      "test_tool",
+		Arguments: map[string]interface{}{"device": "emulator-5554"},
+	})
+
+	entries := readAuditLog(t, path)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 audit entries, got %d", len(entries))
+	}
+
+	entry := entries[0]
+	if entry.Client != clientInfo || entry.Tool != "test_tool" || entry.Device != "emulator-5554" || entry.Outcome != "ok" {
+		t.Errorf("unexpected audit entry: %+v", entry)
+	}
+	wantArguments := map[string]interface{}{"device": "emulator-5554", "auth_token": "[REDACTED]", "keycode": float64(66)}
+	if !reflect.DeepEqual(entry.Arguments, wantArguments) {
+		t.Errorf("expected arguments %v, got %v", wantArguments, entry.Arguments)
+	}
+
+	// The device lookup and the handler's adb command are both recorded
+	if len(entry.Commands) != 2 {
+		t.Fatalf("expected 2 adb commands, got %+v", entry.Commands)
+	}
+	command := entry.Commands[1]
+	if got := strings.Join(command.Argv[len(command.Argv)-5:], " "); got != "-s emulator-5554 shell getprop [REDACTED]" {
+		t.Errorf("expected redacted argv, got %q", got)
+	}
+	if command.ExitCode == nil || *command.ExitCode != 0 {
+		t.Errorf("expected exit code 0, got %v", command.ExitCode)
+	}
+
+	if entries[1].Outcome != "blocked" || !strings.Contains(entries[1].Error, "blocked by safety policy") {
+		t.Errorf("expected blocked call to be audited, got %+v", entries[1])
+	}
+}
+
+func TestAuditEmulatorCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	useConfig(t, &Config{AuditLog: AuditConfig{Path: path}})
+
+	record := beginAudit("test_tool", map[string]interface{}{"auth_token": "s3cret"})
+	ctx := withAudit(context.Background(), record)
+	recordAuditProcess(ctx, exec.Command("emulator", "-avd", "Pixel_7", "-prop", "token=s3cret"))
+	// Commands of other calls or of background work are not attributed to this call
+	recordAuditConsole(withAudit(context.Background(), beginAudit("other_tool", nil)), "rotate")
+	recordAuditCommand(context.Background(), exec.Command("adb", "devices", "-l"))
+	recordAuditConsole(ctx, "sms send 5551234 s3cret")
+	record.end()
+
+	entries := readAuditLog(t, path)
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 audit entry, got %d", len(entries))
+	}
+	want := []AuditCommand{
+		{Argv: []string{"emulator", "-avd", "Pixel_7", "-prop", "token=[REDACTED]"}},
+		{Console: "sms send 5551234 [REDACTED]"},
+	}
+	if !reflect.DeepEqual(entries[0].Commands, want) {
+		t.Errorf("expected commands %+v, got %+v", want, entries[0].Commands)
+	}
+}
+
+func TestRotateAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	config := AuditConfig{Path: path, MaxSizeMB: 0.0001, MaxFiles: 2}
+
+	for i := 0; i < 8; i++ {
+		if err := writeAuditEntry(config, AuditEntry{Tool: "test_tool", Outcome: "ok"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("expected %s to exist: %v", name, err)
+		}
+		if info.Size() > 105 {
+			t.Errorf("expected %s to be rotated at the size limit, got %d bytes", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected no more than 2 rotated files, got %v", err)
+	}
+}
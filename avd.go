@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var bootPollInterval = 2 * time.Second
+
+var defaultEmulatorBootTimeout = 300 * time.Second
+
+// AVD represents an Android Virtual Device configuration
+type AVD struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name,omitempty"`
+	Target      string `json:"target,omitempty"`
+	APILevel    string `json:"api_level,omitempty"`
+	ABI         string `json:"abi,omitempty"`
+	Skin        string `json:"skin,omitempty"`
+	Path        string `json:"path,omitempty"`
+}
+
+// EmulatorStartOptions controls how an emulator is launched
+type EmulatorStartOptions struct {
+	Headless    bool
+	WipeData    bool
+	ColdBoot    bool
+	Snapshot    string
+	BootTimeout time.Duration
+	// Progress, if set, receives the boot progress as a percentage and a description of the current stage
+	Progress func(percent float64, message string)
+}
+
+// findEmulatorBinary locates the emulator executable in PATH or the Android SDK
+func findEmulatorBinary() (string, error) {
+	if path, err := lookPath("emulator"); err == nil {
+		return path, nil
+	}
+
+	name := "emulator"
+	if filepath.Separator == '\\' {
+		name = "emulator.exe"
+	}
+	for _, env := range []string{"ANDROID_HOME", "ANDROID_SDK_ROOT"} {
+		if sdk := os.Getenv(env); sdk != "" {
+			candidate := filepath.Join(sdk, "emulator", name)
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("emulator command not found in PATH, ANDROID_HOME or ANDROID_SDK_ROOT")
+}
+
+// avdHomeDir returns the directory holding <name>.ini files, honoring the SDK environment overrides
+func avdHomeDir() (string, error) {
+	if dir := os.Getenv("ANDROID_AVD_HOME"); dir != "" {
+		return dir, nil
+	}
+	if dir := os.Getenv("ANDROID_EMULATOR_HOME"); dir != "" {
+		return filepath.Join(dir, "avd"), nil
+	}
+	home, err := userHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, ".android", "avd"), nil
+}
+
+func getAVDList() ([]AVD, error) {
+	emulatorPath, err := findEmulatorBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := execCommand(emulatorPath, "-list-avds")
+	output, err := outputWithTimeout(cmd, commandTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error running emulator -list-avds: %w%s", err, commandStderr(err))
+	}
+
+	avdHome, err := avdHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var avds []AVD
+	for _, line := range strings.Split(string(output), "\n") {
+		name := strings.TrimSpace(line)
+		// Newer emulator versions interleave log lines such as "INFO    | ..."
+		if name == "" || strings.Contains(name, "|") {
+			continue
+		}
+
+		avd := AVD{Name: name}
+		if err := loadAVDConfig(avdHome, &avd); err != nil {
+			logMessage("warning", "avd", "Failed to read config for AVD %s: %v", name, err)
+		}
+		avds = append(avds, avd)
+	}
+
+	return avds, nil
+}
+
+// loadAVDConfig fills in target, ABI and skin from <name>.ini and the AVD's config.ini
+func loadAVDConfig(avdHome string, avd *AVD) error {
+	rootConfig, err := readIniFile(filepath.Join(avdHome, avd.Name+".ini"))
+	if err != nil {
+		return err
+	}
+
+	avd.Path = rootConfig["path"]
+	if avd.Path == "" {
+		avd.Path = filepath.Join(avdHome, avd.Name+".avd")
+	}
+	avd.Target = rootConfig["target"]
+
+	config, err := readIniFile(filepath.Join(avd.Path, "config.ini"))
+	if err != nil {
+		return err
+	}
+
+	avd.DisplayName = config["avd.ini.displayname"]
+	avd.ABI = config["abi.type"]
+	avd.Skin = config["skin.name"]
+	if target := config["target"]; target != "" {
+		avd.Target = target
+	}
+
+	// image.sysdir.1 looks like "system-images/android-30/google_apis/x86/"
+	sysdir := strings.FieldsFunc(config["image.sysdir.1"], func(r rune) bool { return r == '/' || r == '\\' })
+	if len(sysdir) >= 2 && avd.Target == "" {
+		avd.Target = sysdir[1]
+	}
+	if strings.HasPrefix(avd.Target, "android-") {
+		avd.APILevel = strings.TrimPrefix(avd.Target, "android-")
+	}
+
+	return nil
+}
+
+func readIniFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values, scanner.Err()
+}
+
+// findFreeEmulatorPort picks the first even console port in the emulator range whose console and adb ports are free
+func findFreeEmulatorPort(ctx context.Context) (int, error) {
+	devices, err := listAdbDevices(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Only emulators on this machine compete for its ports
+	used := make(map[int]bool)
+	for _, device := range devices {
+		if _, server := deviceServer(device.qualifiedName()); !server.isLocal() {
+			continue
+		}
+		if port, err := emulatorConsolePort(device.Device); err == nil {
+			used[port] = true
+		}
+	}
+
+	// An emulator still booting or another program may hold the ports without adb listing them
+	for port := 5554; port <= 5682; port += 2 {
+		if !used[port] && portAvailable(port) && portAvailable(port+1) {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free emulator port available")
+}
+
+// portAvailable reports whether a TCP port can be bound on loopback, where the emulator listens
+func portAvailable(port int) bool {
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+func startEmulator(ctx context.Context, avdName string, options EmulatorStartOptions) (string, error) {
+	emulatorPath, err := findEmulatorBinary()
+	if err != nil {
+		return "", err
+	}
+
+	port, err := findFreeEmulatorPort(ctx)
+	if err != nil {
+		return "", err
+	}
+	deviceName := "emulator-" + strconv.Itoa(port)
+
+	args := []string{"-avd", avdName, "-port", strconv.Itoa(port)}
+	if options.Headless {
+		args = append(args, "-no-window", "-no-audio", "-no-boot-anim")
+	}
+	if options.WipeData {
+		args = append(args, "-wipe-data")
+	}
+	if options.ColdBoot {
+		args = append(args, "-no-snapshot-load")
+	} else if options.Snapshot != "" {
+		args = append(args, "-snapshot", options.Snapshot)
+	}
+
+	// The emulator keeps logging for its whole lifetime, so only keep the tail for diagnostics
+	output := &tailBuffer{limit: 4096}
+	cmd := execCommand(emulatorPath, args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	recordAuditProcess(ctx, cmd)
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("failed to start emulator %s: %w", avdName, err)
+	}
+
+	// The emulator outlives this call, so shutdown stops it unless configured to keep it running
+	untrack := trackProcess(cmd, "emulator "+deviceName)
+	startedEmulatorsMutex.Lock()
+	startedEmulators[deviceName] = cmd
+	startedEmulatorsMutex.Unlock()
+
+	exited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		startedEmulatorsMutex.Lock()
+		delete(startedEmulators, deviceName)
+		startedEmulatorsMutex.Unlock()
+		untrack()
+		exited <- err
+	}()
+
+	timeout := options.BootTimeout
+	if timeout <= 0 {
+		timeout = currentConfig().emulatorBootTimeout()
+	}
+	started := time.Now()
+	deadline := started.Add(timeout)
+
+	progress := func(percent float64, message string) {
+		if options.Progress != nil {
+			options.Progress(percent, message)
+		}
+	}
+	progress(5, fmt.Sprintf("Started emulator for AVD %s as %s", avdName, deviceName))
+
+	// Boot has two stages, waiting for adb to reach the device and then for sys.boot_completed;
+	// within a stage progress creeps toward 90% as the timeout is used up
+	stage, stageBase := "come online", 5.0
+	for {
+		select {
+		case err := <-exited:
+			return "", fmt.Errorf("emulator %s exited before boot completed: %v, output: %s", avdName, err, output.String())
+		default:
+		}
+
+		// Errors are expected while the device is still offline, so keep polling
+		bootCmd := adbCommand(ctx, "-s", deviceName, "shell", "getprop", "sys.boot_completed")
+		bootOutput, err := outputWithTimeout(bootCmd, commandTimeout)
+		if err == nil && strings.TrimSpace(string(bootOutput)) == "1" {
+			progress(100, fmt.Sprintf("Emulator %s booted", deviceName))
+			return deviceName, nil
+		}
+
+		elapsed := time.Since(started)
+		if err == nil && stageBase < 40 {
+			stage, stageBase = "finish booting", 40
+			progress(stageBase, fmt.Sprintf("%s is online, waiting for boot to complete", deviceName))
+		} else {
+			percent := stageBase + (90-stageBase)*min(1, elapsed.Seconds()/timeout.Seconds())
+			progress(percent, fmt.Sprintf("Waiting for %s to %s (%s elapsed)", deviceName, stage, elapsed.Round(time.Second)))
+		}
+
+		if time.Now().After(deadline) {
+			// Stop the emulator, otherwise a retry would start a second one on another port
+			cmd.Process.Kill()
+			<-exited
+			return "", fmt.Errorf("emulator %s did not finish booting within %s, output: %s", deviceName, timeout, output.String())
+		}
+		time.Sleep(bootPollInterval)
+	}
+}
+
+// tailBuffer is an io.Writer that retains only the last limit bytes written to it
+type tailBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf.Write(p)
+	if excess := b.buf.Len() - b.limit; excess > 0 {
+		b.buf.Next(excess)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+var (
+	startedEmulatorsMutex sync.Mutex
+	startedEmulators      = make(map[string]*exec.Cmd)
+)
+
+func init() {
+	onShutdown("started emulators", stopStartedEmulators)
+}
+
+// stopStartedEmulators shuts down the emulators this server started, letting them save their quick-boot state
+func stopStartedEmulators(ctx context.Context) error {
+	startedEmulatorsMutex.Lock()
+	emulators := make(map[string]*exec.Cmd, len(startedEmulators))
+	for deviceName, cmd := range startedEmulators {
+		emulators[deviceName] = cmd
+	}
+	startedEmulatorsMutex.Unlock()
+
+	var errs []error
+	for deviceName, cmd := range emulators {
+		if currentConfig().KeepEmulatorsRunning {
+			detachProcess(cmd)
+			continue
+		}
+		if ctx.Err() != nil {
+			// Out of time: shutdown kills the remaining emulator processes directly
+			break
+		}
+		if err := killEmulator(ctx, deviceName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func killEmulator(ctx context.Context, deviceName string) error {
+	if _, err := emulatorConsolePort(deviceName); err != nil {
+		return err
+	}
+	// adb emu talks to the console from this machine whatever server -H names
+	if err := checkLocalEmulator(deviceName); err != nil {
+		return err
+	}
+
+	cmd := adbCommand(ctx, "-s", deviceName, "emu", "kill")
+	output, err := runWithTimeout(cmd, commandTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to kill emulator %s: %w, output: %s", deviceName, err, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// cliCommand is a subcommand that runs a server action directly from a terminal
+type cliCommand struct {
+	name        string
+	usage       string
+	description string
+	run         func(flags *flag.FlagSet, args []string, stdout io.Writer) error
+}
+
+// cliUsageError is a mistake in the command line; an empty message means the flag package already reported it
+type cliUsageError struct {
+	message string
+}
+
+func (e cliUsageError) Error() string {
+	return e.message
+}
+
+// cliCommands lists the subcommands in the order the usage text shows them
+var cliCommands = []cliCommand{
+	{
+		name:        "devices",
+		usage:       "devices [-json]",
+		description: "List connected devices with their details",
+		run:         runDevicesCommand,
+	},
+	{
+		name:        "screenshot",
+		usage:       "screenshot [-device selector] [-o file] [-format png|jpeg] [-jpeg-quality n] [-max-dimension n] [-json]",
+		description: "Save a screenshot of a device",
+		run:         runScreenshotCommand,
+	},
+	{
+		name:        "ui-dump",
+		usage:       "ui-dump [-device selector] [-o file] [-json]",
+		description: "Print the UI hierarchy of the current screen as XML",
+		run:         runUIDumpCommand,
+	},
+	{
+		name:        "logcat",
+		usage:       "logcat [-device selector] [-lines n] [-buffer name] [-follow] [-json] [filterspec...]",
+		description: "Print recent log lines, or stream them with -follow",
+		run:         runLogcatCommand,
+	},
+	{
+		name:        "tap",
+		usage:       "tap [-device selector] [-json] x y",
+		description: "Tap the screen at pixel coordinates",
+		run:         runTapCommand,
+	},
+}
+
+// printUsage describes the MCP server mode and the subcommands
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: mcp_android_devices [-config file]                  run the MCP server on stdio")
+	fmt.Fprintln(w, "       mcp_android_devices [-config file] command [flags]  run one action and print the result")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, command := range cliCommands {
+		fmt.Fprintf(table, "  %s\t%s\n", command.name, command.description)
+	}
+	table.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run \"mcp_android_devices command -h\" for the flags of a command.")
+}
+
+// runCLI runs one subcommand and returns the process exit status: 0 on success, 1 on failure, 2 on a usage error
+func runCLI(args []string, stdout io.Writer, stderr io.Writer) int {
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		printUsage(stdout)
+		return 0
+	}
+
+	for _, command := range cliCommands {
+		if command.name != name {
+			continue
+		}
+
+		flags := flag.NewFlagSet(name, flag.ContinueOnError)
+		flags.SetOutput(stderr)
+		flags.Usage = func() {
+			fmt.Fprintf(stderr, "usage: mcp_android_devices %s\n\n%s.\n\n", command.usage, command.description)
+			flags.PrintDefaults()
+		}
+
+		err := command.run(flags, args[1:], stdout)
+		var usageErr cliUsageError
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, flag.ErrHelp):
+			return 0
+		case errors.As(err, &usageErr):
+			if usageErr.message != "" {
+				fmt.Fprintf(stderr, "%s: %s\n", name, usageErr.message)
+				flags.Usage()
+			}
+			return 2
+		default:
+			fmt.Fprintf(stderr, "%s: %v\n", name, err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(stderr, "unknown command %q\n\n", name)
+	printUsage(stderr)
+	return 2
+}
+
+// parseCLIFlags parses args, reporting a bad flag as a usage error
+func parseCLIFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return cliUsageError{}
+	}
+	return nil
+}
+
+// printJSON writes value as indented JSON
+func printJSON(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// writeOutput writes data to path, or to stdout when path is "-"
+func writeOutput(path string, data []byte, stdout io.Writer) error {
+	if path == "-" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	if err := currentConfig().checkWritePath(path); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// shellQuote quotes an argument for the device shell
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+func runDevicesCommand(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	jsonOutput := flags.Bool("json", false, "print the device list as JSON, as get_android_devices returns it")
+	if err := parseCLIFlags(flags, args); err != nil {
+		return err
+	}
+
+	devices, err := getDeviceList(context.Background())
+	if err != nil {
+		return err
+	}
+	if devices == nil {
+		devices = []Device{}
+	}
+
+	if *jsonOutput {
+		return printJSON(stdout, devices)
+	}
+
+	if len(devices) == 0 {
+		fmt.Fprintln(stdout, "No devices attached")
+		return nil
+	}
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "SERIAL\tSTATUS\tNAME\tMODEL\tANDROID\tSDK")
+	for _, device := range devices {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			device.Device, device.RunStatus, device.Name, device.Model, device.AndroidVersion, device.SDKLevel)
+	}
+	return table.Flush()
+}
+
+func runScreenshotCommand(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	device := flags.String("device", "", "device selector (serial, AVD name, model or filter); the first ready device if empty")
+	output := flags.String("o", "", "output file, or - for stdout (default screenshot.png or screenshot.jpg)")
+	format := flags.String("format", "", "image format, png or jpeg (default from the config file, else png)")
+	jpegQuality := flags.Int("jpeg-quality", 0, "JPEG quality from 1 to 100")
+	maxDimension := flags.Int("max-dimension", 0, "scale the image down so its longest side is at most this many pixels")
+	jsonOutput := flags.Bool("json", false, "print where the screenshot was saved as JSON")
+	if err := parseCLIFlags(flags, args); err != nil {
+		return err
+	}
+
+	// Only flags given on the command line override the configured defaults, as with tool arguments
+	arguments := map[string]interface{}{}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "format":
+			arguments["format"] = *format
+		case "jpeg-quality":
+			arguments["jpeg_quality"] = float64(*jpegQuality)
+		case "max-dimension":
+			arguments["max_dimension"] = float64(*maxDimension)
+		}
+	})
+	// Validate the flags against the tool's own schema so the CLI accepts exactly what the agent may send
+	definition, _ := toolRegistry.Lookup("get_android_screen")
+	if err := validateSchema(definition.InputSchema, arguments, ""); err != nil {
+		return cliUsageError{message: err.Error()}
+	}
+	options := screenshotOptions(arguments)
+
+	serial, err := resolveDeviceSelector(context.Background(), *device, false)
+	if err != nil {
+		return err
+	}
+	data, mimeType, err := captureScreenshot(context.Background(), serial, options)
+	if err != nil {
+		return err
+	}
+	image, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode screenshot: %w", err)
+	}
+
+	path := *output
+	if path == "" {
+		path = "screenshot.png"
+		if mimeType == "image/jpeg" {
+			path = "screenshot.jpg"
+		}
+	}
+	if err := writeOutput(path, image, stdout); err != nil {
+		return err
+	}
+	if path == "-" {
+		return nil
+	}
+
+	if *jsonOutput {
+		return printJSON(stdout, map[string]interface{}{
+			"device":    serial,
+			"path":      path,
+			"mime_type": mimeType,
+			"bytes":     len(image),
+		})
+	}
+	fmt.Fprintf(stdout, "Saved screenshot of %s to %s (%d bytes)\n", serial, path, len(image))
+	return nil
+}
+
+func runUIDumpCommand(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	device := flags.String("device", "", "device selector (serial, AVD name, model or filter); the first ready device if empty")
+	output := flags.String("o", "-", "output file, or - for stdout")
+	jsonOutput := flags.Bool("json", false, "print the device and hierarchy as JSON")
+	if err := parseCLIFlags(flags, args); err != nil {
+		return err
+	}
+
+	serial, err := resolveDeviceSelector(context.Background(), *device, false)
+	if err != nil {
+		return err
+	}
+	contents, err := readUIResource(serial)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		return printJSON(stdout, map[string]interface{}{
+			"device": serial,
+			"xml":    contents.Text,
+		})
+	}
+	return writeOutput(*output, []byte(contents.Text+"\n"), stdout)
+}
+
+func runLogcatCommand(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	device := flags.String("device", "", "device selector (serial, AVD name, model or filter); the first ready device if empty")
+	lines := flags.Int("lines", 500, "number of recent lines to print; 0 prints the whole buffer")
+	buffer := flags.String("buffer", "", "log buffer to read, e.g. main, system or crash (default main, system and crash)")
+	follow := flags.Bool("follow", false, "keep streaming new lines until interrupted")
+	jsonOutput := flags.Bool("json", false, "print the shell result as JSON, as android_shell returns it")
+	if err := parseCLIFlags(flags, args); err != nil {
+		return err
+	}
+	if *lines < 0 {
+		return cliUsageError{message: "-lines must not be negative"}
+	}
+	if *follow && *jsonOutput {
+		return cliUsageError{message: "-json cannot be used with -follow"}
+	}
+
+	serial, err := resolveDeviceSelector(context.Background(), *device, false)
+	if err != nil {
+		return err
+	}
+
+	// -t implies -d, so following starts from the recent lines with -T instead
+	logcatArgs := []string{"logcat"}
+	switch {
+	case *follow && *lines > 0:
+		logcatArgs = append(logcatArgs, "-T", strconv.Itoa(*lines))
+	case !*follow:
+		logcatArgs = append(logcatArgs, "-d")
+		if *lines > 0 {
+			logcatArgs = append(logcatArgs, "-t", strconv.Itoa(*lines))
+		}
+	}
+	if *buffer != "" {
+		logcatArgs = append(logcatArgs, "-b", *buffer)
+	}
+	// Filter specs such as *:E would be expanded by the device shell unless quoted
+	for _, spec := range flags.Args() {
+		logcatArgs = append(logcatArgs, shellQuote(spec))
+	}
+
+	if *follow {
+		cmd := adbCommand(context.Background(), "-s", serial, "shell", strings.Join(logcatArgs, " "))
+		cmd.Stdout = stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to start logcat on device %s: %w", serial, err)
+		}
+		untrack := trackProcess(cmd, "logcat on "+serial)
+		defer untrack()
+		return cmd.Wait()
+	}
+
+	result, err := runShellCommand(context.Background(), serial, strings.Join(logcatArgs, " "), currentConfig().shellTimeout())
+	if err != nil {
+		return err
+	}
+	return printShellResult(stdout, result, *jsonOutput, func() {
+		fmt.Fprint(stdout, result.Stdout)
+	})
+}
+
+func runTapCommand(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	device := flags.String("device", "", "device selector (serial, AVD name, model or filter); the first ready device if empty")
+	jsonOutput := flags.Bool("json", false, "print the shell result as JSON, as android_shell returns it")
+	if err := parseCLIFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return cliUsageError{message: "expected x and y coordinates"}
+	}
+	x, errX := strconv.Atoi(flags.Arg(0))
+	y, errY := strconv.Atoi(flags.Arg(1))
+	if errX != nil || errY != nil || x < 0 || y < 0 {
+		return cliUsageError{message: "coordinates must be non-negative integers"}
+	}
+
+	serial, err := resolveDeviceSelector(context.Background(), *device, false)
+	if err != nil {
+		return err
+	}
+	result, err := runShellCommand(context.Background(), serial, fmt.Sprintf("input tap %d %d", x, y), currentConfig().shellTimeout())
+	if err != nil {
+		return err
+	}
+	return printShellResult(stdout, result, *jsonOutput, func() {
+		fmt.Fprintf(stdout, "Tapped %d,%d on %s\n", x, y, serial)
+	})
+}
+
+// printShellResult prints result as JSON or with printHuman, failing when the device command did
+func printShellResult(stdout io.Writer, result ShellResult, jsonOutput bool, printHuman func()) error {
+	if jsonOutput {
+		if err := printJSON(stdout, result); err != nil {
+			return err
+		}
+	} else if result.ExitCode == 0 {
+		printHuman()
+	}
+
+	if result.ExitCode != 0 {
+		return fmt.Errorf("command exited with status %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr+"\n"+result.Stdout))
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCLI(t *testing.T) {
+	originalExecCommand := execCommand
+	execCommand = helperCommand
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) { return file, nil }
+	defer func() {
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+	}()
+
+	run := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		status := runCLI(args, &stdout, &stderr)
+		return status, stdout.String(), stderr.String()
+	}
+
+	t.Run("Devices", func(t *testing.T) {
+		status, stdout, stderr := run("devices")
+		if status != 0 {
+			t.Fatalf("status %d: %s", status, stderr)
+		}
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "SERIAL") || !strings.HasPrefix(lines[1], "emulator-5554") {
+			t.Errorf("unexpected table:\n%s", stdout)
+		}
+	})
+
+	t.Run("DevicesJSON", func(t *testing.T) {
+		status, stdout, stderr := run("devices", "-json")
+		if status != 0 {
+			t.Fatalf("status %d: %s", status, stderr)
+		}
+		var devices []Device
+		if err := json.Unmarshal([]byte(stdout), &devices); err != nil {
+			t.Fatalf("invalid JSON %q: %v", stdout, err)
+		}
+		if len(devices) != 1 || devices[0].Device != "emulator-5554" || devices[0].SDKLevel != "30" {
+			t.Errorf("unexpected devices: %+v", devices)
+		}
+	})
+
+	t.Run("Screenshot", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "screen.jpg")
+		status, stdout, stderr := run("screenshot", "-o", path, "-format", "jpeg", "-json")
+		if status != 0 {
+			t.Fatalf("status %d: %s", status, stderr)
+		}
+		var result map[string]interface{}
+		if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+			t.Fatalf("invalid JSON %q: %v", stdout, err)
+		}
+		if result["device"] != "emulator-5554" || result["path"] != path || result["mime_type"] != "image/jpeg" {
+			t.Errorf("unexpected result: %v", result)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+			t.Errorf("expected a JPEG file, got %d bytes: %v", len(data), err)
+		}
+	})
+
+	t.Run("ScreenshotInvalidQuality", func(t *testing.T) {
+		status, _, stderr := run("screenshot", "-jpeg-quality", "101")
+		if status != 2 || !strings.Contains(stderr, "jpeg_quality") {
+			t.Errorf("expected a usage error about jpeg_quality, got status %d: %s", status, stderr)
+		}
+	})
+
+	t.Run("UIDump", func(t *testing.T) {
+		status, stdout, stderr := run("ui-dump", "-device", "emulator-5554")
+		if status != 0 {
+			t.Fatalf("status %d: %s", status, stderr)
+		}
+		if !strings.HasSuffix(stdout, "</hierarchy>\n") {
+			t.Errorf("unexpected dump: %q", stdout)
+		}
+	})
+
+	t.Run("Logcat", func(t *testing.T) {
+		status, stdout, stderr := run("logcat", "-lines", "5", "ActivityManager:I", "*:S")
+		if status != 0 {
+			t.Fatalf("status %d: %s", status, stderr)
+		}
+		if !strings.Contains(stdout, "logcat -d -t 5 'ActivityManager:I' '*:S'") {
+			t.Errorf("unexpected logcat output: %q", stdout)
+		}
+	})
+
+	t.Run("LogcatFollow", func(t *testing.T) {
+		// The helper echoes the shell command, which must stream (-T) rather than dump (-t implies -d)
+		status, stdout, stderr := run("logcat", "-follow", "-lines", "5", "*:E")
+		if status != 0 {
+			t.Fatalf("status %d: %s", status, stderr)
+		}
+		if !strings.HasSuffix(stdout, "ActivityManager: logcat -T 5 '*:E'\n") {
+			t.Errorf("unexpected logcat command: %q", stdout)
+		}
+	})
+
+	t.Run("Tap", func(t *testing.T) {
+		status, stdout, stderr := run("tap", "10", "20")
+		if status != 0 || stdout != "Tapped 10,20 on emulator-5554\n" {
+			t.Errorf("status %d, stdout %q, stderr %q", status, stdout, stderr)
+		}
+	})
+
+	t.Run("TapFailure", func(t *testing.T) {
+		status, stdout, stderr := run("tap", "-json", "0", "0")
+		if status != 1 || !strings.Contains(stderr, "input: no display") {
+			t.Errorf("expected the device error, got status %d: %s", status, stderr)
+		}
+		var result ShellResult
+		if err := json.Unmarshal([]byte(stdout), &result); err != nil || result.ExitCode != 1 {
+			t.Errorf("expected the shell result as JSON, got %q", stdout)
+		}
+	})
+
+	t.Run("UsageErrors", func(t *testing.T) {
+		for _, args := range [][]string{{"tap", "10"}, {"devices", "-bogus"}, {"unknown"}} {
+			if status, _, _ := run(args...); status != 2 {
+				t.Errorf("%v: expected status 2, got %d", args, status)
+			}
+		}
+	})
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// configEnvVar names the environment variable that points at the config file when -config is not given
+const configEnvVar = "MCP_ANDROID_CONFIG"
+
+var configPollInterval = 2 * time.Second
+
+// Config holds the server behavior loaded from the JSON config file
+type Config struct {
+	ADBPath            string            `json:"adb_path,omitempty"`
+	ADBServerHost      string            `json:"adb_server_host,omitempty"`
+	ADBServerPort      int               `json:"adb_server_port,omitempty"`
+	ADBServers         []string          `json:"adb_servers,omitempty"`
+	DefaultDevice      string            `json:"default_device,omitempty"`
+	DeviceAliases      map[string]string `json:"device_aliases,omitempty"`
+	Tools              map[string]bool   `json:"tools,omitempty"`
+	Timeouts           TimeoutConfig     `json:"timeouts,omitempty"`
+	Screenshot         ScreenshotConfig  `json:"screenshot,omitempty"`
+	SandboxDirectories []string          `json:"sandbox_directories,omitempty"`
+	Policy             PolicyConfig      `json:"policy,omitempty"`
+	AuditLog           AuditConfig       `json:"audit_log,omitempty"`
+	Shell              ShellConfig       `json:"shell,omitempty"`
+	// KeepEmulatorsRunning leaves emulators started by android_start_emulator running when the server exits
+	KeepEmulatorsRunning bool `json:"keep_emulators_running,omitempty"`
+}
+
+// TimeoutConfig overrides the built-in timeouts, in seconds; zero keeps the default
+type TimeoutConfig struct {
+	DeviceDetailsSeconds float64 `json:"device_details_seconds,omitempty"`
+	EmulatorBootSeconds  float64 `json:"emulator_boot_seconds,omitempty"`
+	ConsoleSeconds       float64 `json:"console_seconds,omitempty"`
+	ScreenshotSeconds    float64 `json:"screenshot_seconds,omitempty"`
+	ShellSeconds         float64 `json:"shell_seconds,omitempty"`
+	ShutdownSeconds      float64 `json:"shutdown_seconds,omitempty"`
+}
+
+// ScreenshotConfig holds the defaults for get_android_screen arguments
+type ScreenshotConfig struct {
+	Format       string `json:"format,omitempty"`
+	JPEGQuality  int    `json:"jpeg_quality,omitempty"`
+	MaxDimension int    `json:"max_dimension,omitempty"`
+}
+
+var activeConfig atomic.Pointer[Config]
+
+// currentConfig returns the active configuration, which is empty when no config file is used
+func currentConfig() *Config {
+	if config := activeConfig.Load(); config != nil {
+		return config
+	}
+	return &Config{}
+}
+
+func setConfig(config *Config) {
+	activeConfig.Store(config)
+}
+
+// configPath returns the config file path from the -config flag value or the environment
+func configPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv(configEnvVar)
+}
+
+// loadConfig reads a JSON config file. YAML is not supported, so .yaml and
+// .yml files are refused with a clear error instead of a JSON syntax error.
+func loadConfig(path string) (*Config, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return nil, fmt.Errorf("config file %s: YAML is not supported, convert it to JSON", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	// Reject unknown keys so a typo does not silently fall back to a default
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// Validate reports the first configuration problem found
+func (c *Config) Validate() error {
+	if c.ADBPath != "" {
+		if _, err := os.Stat(c.ADBPath); err != nil {
+			return fmt.Errorf("adb_path: %w", err)
+		}
+	}
+
+	if c.ADBServerPort < 0 || c.ADBServerPort > 65535 {
+		return fmt.Errorf("adb_server_port must be between 1 and 65535, got %d", c.ADBServerPort)
+	}
+	if len(c.ADBServers) > 0 && (c.ADBServerHost != "" || c.ADBServerPort != 0) {
+		return fmt.Errorf("adb_servers cannot be combined with adb_server_host and adb_server_port")
+	}
+	seenServers := make(map[string]bool)
+	for _, address := range c.ADBServers {
+		server, err := parseADBServer(address)
+		if err != nil {
+			return fmt.Errorf("adb_servers: %w", err)
+		}
+		if seenServers[server.String()] {
+			return fmt.Errorf("adb_servers: %s is listed twice", server)
+		}
+		seenServers[server.String()] = true
+	}
+
+	for alias, selector := range c.DeviceAliases {
+		if alias == "" || selector == "" {
+			return fmt.Errorf("device_aliases: alias %q must map to a non-empty device selector", alias)
+		}
+	}
+
+	for name := range c.Tools {
+		if _, exists := toolRegistry.Lookup(name); !exists {
+			return fmt.Errorf("tools: unknown tool %q", name)
+		}
+	}
+
+	timeouts := map[string]float64{
+		"device_details_seconds": c.Timeouts.DeviceDetailsSeconds,
+		"emulator_boot_seconds":  c.Timeouts.EmulatorBootSeconds,
+		"console_seconds":        c.Timeouts.ConsoleSeconds,
+		"screenshot_seconds":     c.Timeouts.ScreenshotSeconds,
+		"shell_seconds":          c.Timeouts.ShellSeconds,
+		"shutdown_seconds":       c.Timeouts.ShutdownSeconds,
+	}
+	for name, seconds := range timeouts {
+		if seconds < 0 {
+			return fmt.Errorf("timeouts.%s must not be negative", name)
+		}
+	}
+
+	switch c.Screenshot.Format {
+	case "", "png", "jpeg":
+	default:
+		return fmt.Errorf("screenshot.format must be png or jpeg, got %q", c.Screenshot.Format)
+	}
+	if c.Screenshot.JPEGQuality < 0 || c.Screenshot.JPEGQuality > 100 {
+		return fmt.Errorf("screenshot.jpeg_quality must be between 1 and 100, got %d", c.Screenshot.JPEGQuality)
+	}
+	if c.Screenshot.MaxDimension < 0 {
+		return fmt.Errorf("screenshot.max_dimension must not be negative")
+	}
+
+	for _, dir := range c.SandboxDirectories {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("sandbox_directories: %s must be an absolute path", dir)
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("sandbox_directories: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("sandbox_directories: %s is not a directory", dir)
+		}
+	}
+
+	if err := c.Policy.Validate(); err != nil {
+		return err
+	}
+	if err := c.Shell.Validate(); err != nil {
+		return err
+	}
+	return c.AuditLog.Validate()
+}
+
+// checkWritePath refuses host files outside sandbox_directories when any are configured. Symlinks are resolved,
+// so a link inside a sandbox cannot lead out of it.
+func (c *Config) checkWritePath(path string) error {
+	if len(c.SandboxDirectories) == 0 {
+		return nil
+	}
+
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s: %w", path, err)
+	}
+	resolved, err := filepath.EvalSymlinks(absolute)
+	if err != nil {
+		// The file does not exist yet, so resolve its directory
+		dir, dirErr := filepath.EvalSymlinks(filepath.Dir(absolute))
+		if dirErr != nil {
+			return fmt.Errorf("cannot write %s: %w", path, dirErr)
+		}
+		resolved = filepath.Join(dir, filepath.Base(absolute))
+	}
+
+	for _, sandbox := range c.SandboxDirectories {
+		root, err := filepath.EvalSymlinks(sandbox)
+		if err != nil {
+			continue
+		}
+		relative, err := filepath.Rel(root, resolved)
+		if err == nil && relative != "." && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot write %s: it is outside sandbox_directories %s", path, strings.Join(c.SandboxDirectories, ", "))
+}
+
+// adbBinary returns the configured adb executable, or "adb" to look it up in PATH
+func (c *Config) adbBinary() string {
+	if c.ADBPath != "" {
+		return c.ADBPath
+	}
+	return "adb"
+}
+
+func (c *Config) toolEnabled(name string) bool {
+	enabled, configured := c.Tools[name]
+	return !configured || enabled
+}
+
+func (c *Config) deviceDetailsTimeout() time.Duration {
+	return secondsOrDefault(c.Timeouts.DeviceDetailsSeconds, deviceDetailsTimeout)
+}
+
+func (c *Config) emulatorBootTimeout() time.Duration {
+	return secondsOrDefault(c.Timeouts.EmulatorBootSeconds, defaultEmulatorBootTimeout)
+}
+
+func (c *Config) consoleTimeout() time.Duration {
+	return secondsOrDefault(c.Timeouts.ConsoleSeconds, emulatorConsoleTimeout)
+}
+
+func (c *Config) screenshotTimeout() time.Duration {
+	return secondsOrDefault(c.Timeouts.ScreenshotSeconds, screenshotTimeout)
+}
+
+func (c *Config) shellTimeout() time.Duration {
+	return secondsOrDefault(c.Timeouts.ShellSeconds, shellTimeout)
+}
+
+func (c *Config) shutdownTimeout() time.Duration {
+	return secondsOrDefault(c.Timeouts.ShutdownSeconds, shutdownGracePeriod)
+}
+
+func secondsOrDefault(seconds float64, fallback time.Duration) time.Duration {
+	if seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	return fallback
+}
+
+// watchConfig reloads the config file when it changes, keeping the previous config if the new one is invalid
+func watchConfig(path string, stop <-chan struct{}) {
+	lastModified := configModTime(path)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		modified := configModTime(path)
+		if modified.Equal(lastModified) {
+			continue
+		}
+		lastModified = modified
+
+		config, err := loadConfig(path)
+		if err != nil {
+			logMessage("error", "config", "Keeping previous configuration: %v", err)
+			continue
+		}
+		setConfig(config)
+		logMessage("info", "config", "Reloaded configuration from %s", path)
+
+		// Enabled tools may have changed, so let the client refresh its tool list
+		sendNotification("notifications/tools/list_changed", nil)
+	}
+}
+
+func configModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func useConfig(t *testing.T, config *Config) {
+	original := activeConfig.Load()
+	setConfig(config)
+	t.Cleanup(func() { activeConfig.Store(original) })
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"Valid", `{"adb_server_port": 5037, "device_aliases": {"lab": "sdk>=33"}, "tools": {"android_kill_emulator": false}, "screenshot": {"format": "jpeg"}, "sandbox_directories": [` + strings.ReplaceAll(`"`+dir+`"`, `\`, `\\`) + `]}`, ""},
+		{"UnknownKey", `{"adb_pth": "/usr/bin/adb"}`, `unknown field "adb_pth"`},
+		{"UnknownTool", `{"tools": {"android_format_disk": false}}`, `tools: unknown tool "android_format_disk"`},
+		{"BadPort", `{"adb_server_port": 70000}`, "adb_server_port must be between 1 and 65535"},
+		{"BadFormat", `{"screenshot": {"format": "gif"}}`, "screenshot.format must be png or jpeg"},
+		{"NegativeTimeout", `{"timeouts": {"console_seconds": -1}}`, "timeouts.console_seconds must not be negative"},
+		{"BadPolicySafety", `{"policy": {"max_tool_safety": "safe"}}`, "policy.max_tool_safety must be read_only, interactive or destructive"},
+		{"BadBlockedCommand", `{"policy": {"blocked_commands": ["(rm"]}}`, "policy.blocked_commands"},
+		{"RelativeSandbox", `{"sandbox_directories": ["artifacts"]}`, "must be an absolute path"},
+		{"BadADBServer", `{"adb_servers": ["lab-1:http"]}`, "adb_servers: invalid adb server port"},
+		{"ADBServersWithHost", `{"adb_servers": ["lab-1:5037"], "adb_server_host": "lab-2"}`, "adb_servers cannot be combined"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, test.name+".json")
+			writeConfigFile(t, path, test.content)
+
+			config, err := loadConfig(path)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if config.toolEnabled("android_kill_emulator") || !config.toolEnabled("get_android_devices") {
+					t.Error("expected only android_kill_emulator to be disabled")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("expected error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, "adb_server_port: 5037\n")
+
+	if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "YAML is not supported") {
+		t.Errorf("expected YAML to be refused, got %v", err)
+	}
+}
+
+func TestConfigApplied(t *testing.T) {
+	useConfig(t, &Config{
+		ADBServerHost: "lab-host",
+		ADBServerPort: 5038,
+		DefaultDevice: "phone",
+		DeviceAliases: map[string]string{"phone": "R58M123ABC"},
+		Tools:         map[string]bool{"android_kill_emulator": false},
+	})
+
+	var received []string
+	originalExecCommand := execCommand
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		received = append([]string{command}, args...)
+		cmd := helperCommand("adb", args[4:]...)
+		cmd.Env = append(cmd.Env, "HELPER_DEVICES=emulator-5554\tdevice\nR58M123ABC\tdevice usb:1-1")
+		return cmd
+	}
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) { return file, nil }
+	defer func() {
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+	}()
+
+	serial, err := resolveDeviceSelector(context.Background(), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial != "R58M123ABC" {
+		t.Errorf("expected the aliased default device, got %s", serial)
+	}
+	if strings.Join(received[:5], " ") != "adb -H lab-host -P 5038" {
+		t.Errorf("expected adb server flags, got %v", received)
+	}
+
+	for _, tool := range toolRegistry.Tools() {
+		if tool.Name == "android_kill_emulator" {
+			t.Error("expected disabled tool to be hidden from tools/list")
+		}
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfigFile(t, path, `{"default_device": "emulator-5554"}`)
+	useConfig(t, &Config{DefaultDevice: "emulator-5554"})
+
+	originalInterval := configPollInterval
+	configPollInterval = 10 * time.Millisecond
+	notifications := make(chan string, 4)
+	originalSendNotification := sendNotification
+	sendNotification = func(method string, params interface{}) {
+		notifications <- method
+	}
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		configPollInterval = originalInterval
+		sendNotification = originalSendNotification
+	}()
+
+	go watchConfig(path, stop)
+
+	// An invalid edit keeps the previous config
+	time.Sleep(50 * time.Millisecond)
+	writeConfigFile(t, path, `{"default_device": 42}`)
+	os.Chtimes(path, time.Now().Add(time.Second), time.Now().Add(time.Second))
+	time.Sleep(100 * time.Millisecond)
+	if currentConfig().DefaultDevice != "emulator-5554" {
+		t.Fatalf("expected invalid config to be ignored, got %+v", currentConfig())
+	}
+	if method := <-notifications; method != "notifications/message" {
+		t.Errorf("expected the invalid config to be logged to the client, got %s", method)
+	}
+
+	writeConfigFile(t, path, `{"default_device": "R58M123ABC"}`)
+	os.Chtimes(path, time.Now().Add(2*time.Second), time.Now().Add(2*time.Second))
+
+	select {
+	case method := <-notifications:
+		if method != "notifications/tools/list_changed" {
+			t.Errorf("unexpected notification %s", method)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a tools/list_changed notification")
+	}
+	if currentConfig().DefaultDevice != "R58M123ABC" {
+		t.Errorf("expected reloaded config, got %+v", currentConfig())
+	}
+}
+
+func TestEncodeScreenshot(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for x := 0; x < 200; x++ {
+		img.Set(x, 50, color.RGBA{R: 255, A: 255})
+	}
+	var pngData bytes.Buffer
+	png.Encode(&pngData, img)
+
+	data, mimeType, err := encodeScreenshot(pngData.Bytes(), ScreenshotOptions{Format: "jpeg", JPEGQuality: 80, MaxDimension: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mimeType != "image/jpeg" {
+		t.Errorf("expected image/jpeg, got %s", mimeType)
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := decoded.Bounds().Size(); size.X != 50 || size.Y != 25 {
+		t.Errorf("expected 50x25, got %v", size)
+	}
+}
+
+func TestCheckWritePath(t *testing.T) {
+	sandbox := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(sandbox, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{SandboxDirectories: []string{sandbox}}
+
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{filepath.Join(sandbox, "screen.png"), ""},
+		{filepath.Join(outside, "screen.png"), "outside sandbox_directories"},
+		{filepath.Join(sandbox, "..", "screen.png"), "outside sandbox_directories"},
+		{filepath.Join(sandbox, "escape", "screen.png"), "outside sandbox_directories"},
+		{filepath.Join(sandbox, "missing", "screen.png"), "cannot write"},
+	}
+	for _, test := range tests {
+		err := config.checkWritePath(test.path)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.path, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", test.path, test.wantErr, err)
+		}
+	}
+
+	if err := (&Config{}).checkWritePath(filepath.Join(outside, "screen.png")); err != nil {
+		t.Errorf("expected no restriction without sandbox_directories, got %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var emulatorConsoleHost = "127.0.0.1"
+var userHomeDir = os.UserHomeDir
+
+var emulatorConsoleTimeout = 10 * time.Second
+
+// EmulatorConsole is an authenticated connection to an emulator's telnet console
+type EmulatorConsole struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// emulatorConsolePort extracts the console port from an emulator serial such as "emulator-5554"
+func emulatorConsolePort(deviceName string) (int, error) {
+	serial := deviceSerial(deviceName)
+	if !strings.HasPrefix(serial, "emulator-") {
+		return 0, fmt.Errorf("device %s is not an emulator", deviceName)
+	}
+
+	port, err := strconv.Atoi(strings.TrimPrefix(serial, "emulator-"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid emulator serial %s: %w", deviceName, err)
+	}
+	return port, nil
+}
+
+// checkLocalEmulator refuses emulators attached to a remote adb server: their console only listens on loopback
+// of that machine, and `adb emu` connects from the client, so it would reach a local emulator on the same port
+func checkLocalEmulator(deviceName string) error {
+	if _, server := deviceServer(deviceName); !server.isLocal() {
+		return fmt.Errorf("emulator %s is attached to the remote adb server %s; its console can only be reached on that machine", deviceName, server)
+	}
+	return nil
+}
+
+func readEmulatorConsoleAuthToken() (string, error) {
+	home, err := userHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+
+	tokenPath := filepath.Join(home, ".emulator_console_auth_token")
+	data, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read emulator console auth token from %s: %w", tokenPath, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func dialEmulatorConsole(deviceName string) (*EmulatorConsole, error) {
+	port, err := emulatorConsolePort(deviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	address := net.JoinHostPort(emulatorConsoleHost, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", address, currentConfig().consoleTimeout())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to emulator console at %s: %w", address, err)
+	}
+
+	console := &EmulatorConsole{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+
+	// The console greets with a banner terminated by "OK"
+	if _, err := console.readResponse(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read emulator console banner: %w", err)
+	}
+
+	// An empty token file means the user disabled console authentication
+	token, err := readEmulatorConsoleAuthToken()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if token != "" {
+		if _, err := console.Command("auth " + token); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("emulator console authentication failed: %w", err)
+		}
+	}
+
+	return console, nil
+}
+
+// Command sends a single console command and returns its output without the trailing "OK"
+func (c *EmulatorConsole) Command(command string) (string, error) {
+	c.conn.SetDeadline(time.Now().Add(currentConfig().consoleTimeout()))
+	if _, err := fmt.Fprintf(c.conn, "%s\r\n", command); err != nil {
+		return "", fmt.Errorf("failed to send console command: %w", err)
+	}
+	return c.readResponse()
+}
+
+func (c *EmulatorConsole) readResponse() (string, error) {
+	c.conn.SetDeadline(time.Now().Add(currentConfig().consoleTimeout()))
+
+	var lines []string
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("failed to read console response: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "OK" {
+			return strings.Join(lines, "\n"), nil
+		}
+		if strings.HasPrefix(line, "KO") {
+			return "", fmt.Errorf("%s", strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(line, "KO"), ":")))
+		}
+		lines = append(lines, line)
+	}
+}
+
+func (c *EmulatorConsole) Close() error {
+	c.conn.SetDeadline(time.Now().Add(currentConfig().consoleTimeout()))
+	fmt.Fprint(c.conn, "quit\r\n")
+	return c.conn.Close()
+}
+
+// buildEmulatorConsoleCommands translates a console tool action into console command lines
+func buildEmulatorConsoleCommands(action string, arguments map[string]interface{}) ([]string, error) {
+	switch action {
+	case "geo_fix":
+		latitude, ok := getNumberArgument(arguments, "latitude")
+		if !ok {
+			return nil, fmt.Errorf("latitude is required for geo_fix")
+		}
+		longitude, ok := getNumberArgument(arguments, "longitude")
+		if !ok {
+			return nil, fmt.Errorf("longitude is required for geo_fix")
+		}
+		// Note that geo fix takes longitude before latitude
+		command := fmt.Sprintf("geo fix %s %s", formatConsoleNumber(longitude), formatConsoleNumber(latitude))
+		if altitude, ok := getNumberArgument(arguments, "altitude"); ok {
+			command += " " + formatConsoleNumber(altitude)
+		}
+		return []string{command}, nil
+
+	case "sms_send":
+		phoneNumber := sanitizeConsoleArgument(getStringArgument(arguments, "phone_number"))
+		if phoneNumber == "" {
+			return nil, fmt.Errorf("phone_number is required for sms_send")
+		}
+		message := sanitizeConsoleArgument(getStringArgument(arguments, "message"))
+		if message == "" {
+			return nil, fmt.Errorf("message is required for sms_send")
+		}
+		return []string{fmt.Sprintf("sms send %s %s", phoneNumber, message)}, nil
+
+	case "gsm_call":
+		phoneNumber := sanitizeConsoleArgument(getStringArgument(arguments, "phone_number"))
+		if phoneNumber == "" {
+			return nil, fmt.Errorf("phone_number is required for gsm_call")
+		}
+		return []string{"gsm call " + phoneNumber}, nil
+
+	case "battery":
+		var commands []string
+		if level, ok := getNumberArgument(arguments, "battery_level"); ok {
+			if level < 0 || level > 100 {
+				return nil, fmt.Errorf("battery_level must be between 0 and 100")
+			}
+			commands = append(commands, fmt.Sprintf("power capacity %d", int(level)))
+		}
+		if charging, ok := getBoolArgument(arguments, "charging"); ok {
+			if charging {
+				commands = append(commands, "power ac on", "power status charging")
+			} else {
+				commands = append(commands, "power ac off", "power status discharging")
+			}
+		}
+		if len(commands) == 0 {
+			return nil, fmt.Errorf("battery_level or charging is required for battery")
+		}
+		return commands, nil
+
+	case "network":
+		var commands []string
+		if speed := sanitizeConsoleArgument(getStringArgument(arguments, "network_speed")); speed != "" {
+			commands = append(commands, "network speed "+speed)
+		}
+		if delay := sanitizeConsoleArgument(getStringArgument(arguments, "network_delay")); delay != "" {
+			commands = append(commands, "network delay "+delay)
+		}
+		if len(commands) == 0 {
+			return nil, fmt.Errorf("network_speed or network_delay is required for network")
+		}
+		return commands, nil
+
+	case "rotate":
+		return []string{"rotate"}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown emulator console action: %s", action)
+	}
+}
+
+// buildEmulatorSnapshotCommand translates a snapshot tool action into an "avd snapshot" console command
+func buildEmulatorSnapshotCommand(action string, name string) (string, error) {
+	name = sanitizeConsoleArgument(name)
+	switch action {
+	case "list":
+		return "avd snapshot list", nil
+	case "save", "load", "delete":
+		if name == "" {
+			return "", fmt.Errorf("name is required for snapshot %s", action)
+		}
+		if strings.ContainsAny(name, " \t") {
+			return "", fmt.Errorf("snapshot name must not contain whitespace")
+		}
+		return fmt.Sprintf("avd snapshot %s %s", action, name), nil
+	default:
+		return "", fmt.Errorf("unknown snapshot action: %s", action)
+	}
+}
+
+func formatConsoleNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// sanitizeConsoleArgument prevents arguments from smuggling extra console commands
+func sanitizeConsoleArgument(value string) string {
+	value = strings.ReplaceAll(value, "\r", " ")
+	value = strings.ReplaceAll(value, "\n", " ")
+	return strings.TrimSpace(value)
+}
+
+// runEmulatorConsoleCommands sends commands to the emulator console and returns their joined output
+func runEmulatorConsoleCommands(ctx context.Context, deviceName string, commands []string) (string, error) {
+	if err := checkLocalEmulator(deviceName); err != nil {
+		return "", err
+	}
+
+	console, err := dialEmulatorConsole(deviceName)
+	if err != nil {
+		return "", err
+	}
+	defer console.Close()
+
+	var outputs []string
+	for _, command := range commands {
+		recordAuditConsole(ctx, command)
+		output, err := console.Command(command)
+		if err != nil {
+			return "", fmt.Errorf("console command %q failed: %w", command, err)
+		}
+		if output != "" {
+			outputs = append(outputs, output)
+		}
+	}
+	return strings.Join(outputs, "\n"), nil
+}
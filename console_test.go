@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startFakeEmulatorConsole serves the emulator console protocol on a local port and records received commands
+func startFakeEmulatorConsole(t *testing.T, token string) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	commands := make(chan string, 16)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "Android Console: Authentication required\r\n")
+		fmt.Fprint(conn, "Android Console: type 'auth <auth_token>' to authenticate\r\n")
+		fmt.Fprint(conn, "OK\r\n")
+
+		authenticated := false
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			switch {
+			case line == "quit":
+				return
+			case strings.HasPrefix(line, "auth "):
+				if strings.TrimPrefix(line, "auth ") != token {
+					fmt.Fprint(conn, "KO: authentication token does not match ~/.emulator_console_auth_token\r\n")
+					continue
+				}
+				authenticated = true
+				fmt.Fprint(conn, "Android Console: type 'help' for a list of commands\r\nOK\r\n")
+			case !authenticated:
+				fmt.Fprint(conn, "KO: unknown command, try 'help'\r\n")
+			default:
+				commands <- line
+				fmt.Fprint(conn, "OK\r\n")
+			}
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return "emulator-" + port, commands
+}
+
+// useHelperDevices makes adb report the given `adb devices -l` lines through the helper process
+func useHelperDevices(t *testing.T, devices ...string) {
+	originalExecCommand := execCommand
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		cmd := helperCommand(command, args...)
+		cmd.Env = append(cmd.Env, "HELPER_DEVICES="+strings.Join(devices, "\n"))
+		return cmd
+	}
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) { return file, nil }
+	t.Cleanup(func() {
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+	})
+}
+
+func receiveConsoleCommand(t *testing.T, received <-chan string) string {
+	select {
+	case command := <-received:
+		return command
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for console command")
+		return ""
+	}
+}
+
+func useFakeHomeDir(t *testing.T, token string) {
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, ".emulator_console_auth_token"), []byte(token+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	originalUserHomeDir := userHomeDir
+	userHomeDir = func() (string, error) { return home, nil }
+	t.Cleanup(func() { userHomeDir = originalUserHomeDir })
+}
+
+func TestEmulatorConsole(t *testing.T) {
+	t.Run("GeoFix", func(t *testing.T) {
+		useFakeHomeDir(t, "secret")
+		deviceName, received := startFakeEmulatorConsole(t, "secret")
+		useHelperDevices(t, deviceName+"\tdevice")
+
+		var response JSONRPCResponse
+		originalSendResponse := sendResponse
+		sendResponse = func(resp JSONRPCResponse) { response = resp }
+		defer func() { sendResponse = originalSendResponse }()
+
+		handleRequest(JSONRPCRequest{
+			JSONRPC: "2.0",
+			ID:      5,
+			Method:  "tools/call",
+			Params: map[string]interface{}{
+				"name": "android_emulator_console",
+				"arguments": map[string]interface{}{
+					"device":    deviceName,
+					"action":    "geo_fix",
+					"latitude":  52.52,
+					"longitude": 13.405,
+				},
+			},
+		})
+
+		if result, ok := response.Result.(ToolsCallResult); !ok || result.IsError {
+			t.Fatalf("unexpected response: %+v", response)
+		}
+
+		if command := receiveConsoleCommand(t, received); command != "geo fix 13.405 52.52" {
+			t.Errorf("unexpected console command: %q", command)
+		}
+	})
+
+	t.Run("BadToken", func(t *testing.T) {
+		useFakeHomeDir(t, "wrong")
+		deviceName, _ := startFakeEmulatorConsole(t, "secret")
+
+		_, err := runEmulatorConsoleCommands(context.Background(), deviceName, []string{"rotate"})
+		if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+			t.Errorf("expected authentication error, got %v", err)
+		}
+	})
+
+	t.Run("SnapshotSave", func(t *testing.T) {
+		useFakeHomeDir(t, "secret")
+		deviceName, received := startFakeEmulatorConsole(t, "secret")
+		useHelperDevices(t, deviceName+"\tdevice")
+
+		var response JSONRPCResponse
+		originalSendResponse := sendResponse
+		sendResponse = func(resp JSONRPCResponse) { response = resp }
+		defer func() { sendResponse = originalSendResponse }()
+
+		handleRequest(JSONRPCRequest{
+			JSONRPC: "2.0",
+			ID:      6,
+			Method:  "tools/call",
+			Params: map[string]interface{}{
+				"name": "android_emulator_snapshot",
+				"arguments": map[string]interface{}{
+					"device": deviceName,
+					"action": "save",
+					"name":   "clean_state",
+				},
+			},
+		})
+
+		if result, ok := response.Result.(ToolsCallResult); !ok || result.IsError {
+			t.Fatalf("unexpected response: %+v", response)
+		}
+
+		if command := receiveConsoleCommand(t, received); command != "avd snapshot save clean_state" {
+			t.Errorf("unexpected console command: %q", command)
+		}
+	})
+
+	t.Run("RemoteServer", func(t *testing.T) {
+		useConfig(t, &Config{ADBServers: []string{"lab-2:5037"}})
+		useHelperDevices(t)
+
+		wantErr := "emulator emulator-5554@lab-2:5037 is attached to the remote adb server lab-2:5037"
+		if _, err := runEmulatorConsoleCommands(context.Background(), "emulator-5554@lab-2:5037", []string{"avd snapshot list"}); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("expected console commands to be refused, got %v", err)
+		}
+		if err := killEmulator(context.Background(), "emulator-5554@lab-2:5037"); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("expected kill to be refused, got %v", err)
+		}
+	})
+
+	t.Run("NotAnEmulator", func(t *testing.T) {
+		if _, err := emulatorConsolePort("R58M123ABC"); err == nil {
+			t.Error("expected an error for a physical device serial")
+		}
+	})
+}
+
+func TestBuildEmulatorConsoleCommands(t *testing.T) {
+	commands, err := buildEmulatorConsoleCommands("battery", map[string]interface{}{
+		"battery_level": float64(15),
+		"charging":      false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"power capacity 15", "power ac off", "power status discharging"}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("unexpected commands: got %v want %v", commands, expected)
+	}
+
+	commands, err = buildEmulatorConsoleCommands("sms_send", map[string]interface{}{
+		"phone_number": "5551234",
+		"message":      "hello\nkill",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commands[0] != "sms send 5551234 hello kill" {
+		t.Errorf("expected newlines to be stripped, got %q", commands[0])
+	}
+
+	if _, err := buildEmulatorConsoleCommands("geo_fix", map[string]interface{}{}); err == nil {
+		t.Error("expected an error when coordinates are missing")
+	}
+
+	if _, err := buildEmulatorSnapshotCommand("load", ""); err == nil {
+		t.Error("expected an error when the snapshot name is missing")
+	}
+}
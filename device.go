@@ -1,12 +1,52 @@
 package main
 
+import (
+	"reflect"
+	"strings"
+)
+
 // Device represents an Android device
 type Device struct {
-	Name         string `json:"name"`
-	Device       string `json:"device"`
-	Model        string `json:"model"`
-	Arch         string `json:"arch"`
-	AndroidVersion string `json:"android_version"`
-	SDKLevel     string `json:"sdk_level"`
-	RunStatus    string `json:"run_status"`
+	Name           string   `json:"name"`
+	Device         string   `json:"device"`
+	Model          string   `json:"model"`
+	Arch           string   `json:"arch"`
+	AndroidVersion string   `json:"android_version"`
+	SDKLevel       string   `json:"sdk_level"`
+	RunStatus      string   `json:"run_status"`
+	TransportID    string   `json:"transport_id,omitempty"`
+	Host           string   `json:"host,omitempty"`
+	ConnectionType string   `json:"connection_type,omitempty"`
+	Manufacturer   string   `json:"manufacturer,omitempty"`
+	Fingerprint    string   `json:"fingerprint,omitempty"`
+	SecurityPatch  string   `json:"security_patch,omitempty"`
+	ScreenSize     string   `json:"screen_size,omitempty"`
+	ScreenDensity  string   `json:"screen_density,omitempty"`
+	BatteryLevel   string   `json:"battery_level,omitempty"`
+	Locale         string   `json:"locale,omitempty"`
+	BootCompleted  bool     `json:"boot_completed"`
+	ABIs           []string `json:"abis,omitempty"`
+}
+
+// deviceFieldNames lists the JSON field names of Device, in declaration order
+func deviceFieldNames() []string {
+	deviceType := reflect.TypeOf(Device{})
+	names := make([]string, 0, deviceType.NumField())
+	for i := 0; i < deviceType.NumField(); i++ {
+		name, _, _ := strings.Cut(deviceType.Field(i).Tag.Get("json"), ",")
+		names = append(names, name)
+	}
+	return names
+}
+
+// deviceConnectionType classifies how adb reaches a device from its serial
+func deviceConnectionType(serial string) string {
+	switch {
+	case strings.HasPrefix(serial, "emulator-"):
+		return "emulator"
+	case strings.Contains(serial, ":"), strings.Contains(serial, "._adb-tls-connect._tcp"):
+		return "tcp"
+	}
+	// Everything else is attached over USB; adb on Windows does not even report the usb attribute
+	return "usb"
 }
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mcp_android_devices/fakeadb"
+)
+
+// The end-to-end test runs this test binary as the server, which runs it again as its adb client
+const (
+	testServerEnvVar = "MCP_ANDROID_DEVICES_TEST_SERVER"
+	testADBEnvVar    = "MCP_ANDROID_DEVICES_TEST_ADB"
+)
+
+func TestMain(m *testing.M) {
+	if status, ok := replayedProcess(); ok {
+		os.Exit(status)
+	}
+	if os.Getenv(testServerEnvVar) == "1" {
+		// Unset so the adb commands the server runs act as the client instead
+		os.Unsetenv(testServerEnvVar)
+		main()
+	}
+	if os.Getenv(testADBEnvVar) == "1" {
+		os.Exit(fakeadb.RunClient(os.Args[1:], os.Stdout, os.Stderr))
+	}
+	os.Exit(m.Run())
+}
+
+// mcpSession is a server process driven over stdio
+type mcpSession struct {
+	t      *testing.T
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *bufio.Reader
+	stderr bytes.Buffer
+	nextID int
+}
+
+func startMCPServer(t *testing.T, config string) *mcpSession {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	session := &mcpSession{t: t}
+	session.cmd = exec.Command(os.Args[0], "-config", configPath)
+	session.cmd.Env = append(os.Environ(), testServerEnvVar+"=1", testADBEnvVar+"=1")
+	session.cmd.Stderr = &session.stderr
+
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.cmd.Stdin = stdinReader
+	session.stdin = stdinWriter
+	stdout, err := session.cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.stdout = bufio.NewReader(stdout)
+
+	if err := session.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	stdinReader.Close()
+	t.Cleanup(func() {
+		session.stdin.Close()
+		session.cmd.Process.Kill()
+		session.cmd.Wait()
+	})
+	return session
+}
+
+// request sends a request and returns its response, skipping notifications sent in between
+func (s *mcpSession) request(method string, params interface{}) map[string]interface{} {
+	s.nextID++
+	line, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": s.nextID, "method": method, "params": params})
+	if _, err := s.stdin.Write(append(line, '\n')); err != nil {
+		s.t.Fatalf("failed to send %s: %v", method, err)
+	}
+
+	for {
+		responseLine, err := s.stdout.ReadBytes('\n')
+		if err != nil {
+			s.t.Fatalf("no response to %s: %v\nstderr:\n%s", method, err, s.stderr.String())
+		}
+		var response map[string]interface{}
+		if err := json.Unmarshal(responseLine, &response); err != nil {
+			s.t.Fatalf("invalid response line %q: %v", responseLine, err)
+		}
+		if id, ok := response["id"].(float64); ok && int(id) == s.nextID {
+			if response["error"] != nil {
+				s.t.Fatalf("%s failed: %v", method, response["error"])
+			}
+			return response["result"].(map[string]interface{})
+		}
+	}
+}
+
+// callTool calls a tool and fails the test if it reports an error
+func (s *mcpSession) callTool(name string, arguments map[string]interface{}) map[string]interface{} {
+	result := s.request("tools/call", map[string]interface{}{"name": name, "arguments": arguments})
+	if result["isError"] == true {
+		s.t.Fatalf("%s returned an error: %v", name, result["content"])
+	}
+	return result
+}
+
+func TestEndToEnd(t *testing.T) {
+	emulator := fakeadb.NewEmulator("emulator-5554", "Pixel_8_API_34")
+	phone := &fakeadb.Device{
+		Serial: "R5CT1234",
+		Properties: map[string]string{
+			"ro.product.brand":         "samsung",
+			"ro.product.model":         "SM-S911B",
+			"ro.build.version.release": "13",
+			"ro.build.version.sdk":     "33",
+			"sys.boot_completed":       "1",
+		},
+	}
+	server, err := fakeadb.NewServer(emulator, phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	session := startMCPServer(t, fmt.Sprintf(`{"adb_path": %q, "adb_server_host": "127.0.0.1", "adb_server_port": %d}`, os.Args[0], server.Port()))
+	session.request("initialize", map[string]interface{}{
+		"protocolVersion": "2025-06-18",
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]interface{}{"name": "e2e", "version": "1.0.0"},
+	})
+
+	t.Run("Devices", func(t *testing.T) {
+		result := session.callTool("get_android_devices", map[string]interface{}{})
+		devicesJSON, _ := json.Marshal(result["structuredContent"].(map[string]interface{})["devices"])
+		var devices []Device
+		json.Unmarshal(devicesJSON, &devices)
+		if len(devices) != 2 {
+			t.Fatalf("expected both fake devices, got %s", devicesJSON)
+		}
+		if devices[0].Name != "Pixel 8 API 34" || devices[0].SDKLevel != "34" || devices[0].ScreenSize != "108x240" || !devices[0].BootCompleted {
+			t.Errorf("unexpected emulator: %+v", devices[0])
+		}
+		if devices[1].Name != "samsung SM-S911B" || devices[1].ConnectionType != "usb" {
+			t.Errorf("unexpected phone: %+v", devices[1])
+		}
+	})
+
+	t.Run("Screenshot", func(t *testing.T) {
+		result := session.callTool("get_android_screen", map[string]interface{}{"device": "Pixel 8"})
+		content := result["content"].([]interface{})[0].(map[string]interface{})
+		data, _ := base64.StdEncoding.DecodeString(content["data"].(string))
+		image, err := png.Decode(bytes.NewReader(data))
+		if err != nil || image.Bounds().Dy() != 240 {
+			t.Errorf("expected the emulator screen, got %v", err)
+		}
+	})
+
+	t.Run("Shell", func(t *testing.T) {
+		result := session.callTool("android_shell", map[string]interface{}{"device": "emulator-5554", "command": "input tap 10 20"})
+		if output := result["structuredContent"].(map[string]interface{}); output["exit_code"] != float64(0) || output["shell_protocol"] != "v2" {
+			t.Errorf("unexpected shell result: %v", output)
+		}
+		if history := emulator.History(); history[len(history)-1] != "input tap 10 20" {
+			t.Errorf("expected the tap to reach the device, got %q", history)
+		}
+	})
+
+	t.Run("UIResource", func(t *testing.T) {
+		result := session.request("resources/read", map[string]interface{}{"uri": "android://emulator-5554/ui"})
+		contents := result["contents"].([]interface{})[0].(map[string]interface{})
+		if text, _ := contents["text"].(string); !strings.HasSuffix(text, "</hierarchy>") || !strings.Contains(text, `text="Settings"`) {
+			t.Errorf("unexpected UI dump: %v", contents)
+		}
+	})
+
+	// Closing stdin shuts the server down cleanly
+	session.stdin.Close()
+	exited := make(chan error, 1)
+	go func() { exited <- session.cmd.Wait() }()
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("expected a clean exit, got %v\nstderr:\n%s", err, session.stderr.String())
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("server did not exit after stdin was closed")
+	}
+}
+
+func TestEndToEndMultipleServers(t *testing.T) {
+	lab1, err := fakeadb.NewServer(fakeadb.NewEmulator("emulator-5554", "Pixel_8_API_34"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lab1.Close()
+	phone := &fakeadb.Device{Serial: "R5CT1234", Properties: map[string]string{"ro.product.model": "SM-S911B"}}
+	lab2, err := fakeadb.NewServer(fakeadb.NewEmulator("emulator-5554", "Tablet_API_33"), phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lab2.Close()
+	unreachable, err := fakeadb.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable.Close()
+
+	servers, _ := json.Marshal([]string{lab1.Addr(), "tcp:" + lab2.Addr(), unreachable.Addr()})
+	session := startMCPServer(t, fmt.Sprintf(`{"adb_path": %q, "adb_servers": %s}`, os.Args[0], servers))
+	session.request("initialize", map[string]interface{}{
+		"protocolVersion": "2025-06-18",
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]interface{}{"name": "e2e", "version": "1.0.0"},
+	})
+
+	result := session.callTool("get_android_devices", map[string]interface{}{})
+	devices := result["structuredContent"].(map[string]interface{})["devices"].([]interface{})
+	var hosts []string
+	for _, device := range devices {
+		fields := device.(map[string]interface{})
+		hosts = append(hosts, fmt.Sprint(fields["device"], "@", fields["host"]))
+	}
+	want := []string{"emulator-5554@" + lab1.Addr(), "emulator-5554@" + lab2.Addr(), "R5CT1234@" + lab2.Addr()}
+	if strings.Join(hosts, " ") != strings.Join(want, " ") {
+		t.Errorf("expected the devices of both reachable servers, got %v", hosts)
+	}
+
+	// A serial only one server has needs no qualifier
+	session.callTool("android_shell", map[string]interface{}{"device": "R5CT1234", "command": "input tap 1 2"})
+	if history := phone.History(); len(history) == 0 || history[len(history)-1] != "input tap 1 2" {
+		t.Errorf("expected the command on the phone of the second server, got %q", history)
+	}
+
+	// A serial on both servers must be qualified with the host
+	result = session.request("tools/call", map[string]interface{}{"name": "android_shell", "arguments": map[string]interface{}{"device": "emulator-5554", "command": "true"}})
+	if text := fmt.Sprint(result["content"]); result["isError"] != true || !strings.Contains(text, "add @host to pick one") {
+		t.Errorf("expected an ambiguous selector error, got %v", result)
+	}
+	session.callTool("android_shell", map[string]interface{}{"device": "emulator-5554@" + lab2.Addr(), "command": "input tap 3 4"})
+	tablet := lab2.Device("emulator-5554")
+	if history := tablet.History(); len(history) == 0 || history[len(history)-1] != "input tap 3 4" {
+		t.Errorf("expected the command on the emulator of the second server, got %q", history)
+	}
+	if history := lab1.Device("emulator-5554").History(); len(history) > 0 && history[len(history)-1] == "input tap 3 4" {
+		t.Error("the command reached the emulator of the first server")
+	}
+}
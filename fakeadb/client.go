@@ -0,0 +1,259 @@
+package fakeadb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultPort is the port adb servers listen on unless told otherwise
+const defaultPort = 5037
+
+// client is the device selection and server address parsed from adb's global options
+type client struct {
+	address     string
+	serial      string
+	transportID string
+}
+
+// RunClient is a minimal adb command-line client speaking the host protocol, for environments without
+// the Android SDK. It understands the global options -H, -P, -s and -t, the ADB_SERVER_SOCKET and ANDROID_SERIAL
+// variables, and the commands devices, version, features, get-state, get-serialno, shell, exec-out, logcat,
+// start-server and kill-server. It returns the exit status adb would.
+func RunClient(args []string, stdout io.Writer, stderr io.Writer) int {
+	c := client{serial: os.Getenv("ANDROID_SERIAL")}
+	host, port := "127.0.0.1", strconv.Itoa(defaultPort)
+	if socket := os.Getenv("ADB_SERVER_SOCKET"); socket != "" {
+		if address, found := strings.CutPrefix(socket, "tcp:"); found {
+			if strings.Contains(address, ":") {
+				host, port, _ = net.SplitHostPort(address)
+			} else {
+				port = address
+			}
+		}
+	}
+
+	for len(args) > 1 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-H":
+			host = args[1]
+		case "-P":
+			port = args[1]
+		case "-s":
+			c.serial = args[1]
+		case "-t":
+			c.transportID = args[1]
+		default:
+			fmt.Fprintf(stderr, "adb: unknown option %s\n", args[0])
+			return 1
+		}
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "adb: no command given")
+		return 1
+	}
+	c.address = net.JoinHostPort(host, port)
+
+	command, args := args[0], args[1:]
+	var err error
+	status := 0
+	switch command {
+	case "devices":
+		var list string
+		service := "host:devices"
+		if len(args) > 0 && args[0] == "-l" {
+			service = "host:devices-l"
+		}
+		if list, err = c.query(service); err == nil {
+			fmt.Fprint(stdout, "List of devices attached\n"+list+"\n")
+		}
+	case "version":
+		var version string
+		if version, err = c.query("host:version"); err == nil {
+			number, _ := strconv.ParseInt(version, 16, 32)
+			fmt.Fprintf(stdout, "Android Debug Bridge version 1.0.%d\n", number)
+		}
+	case "features", "get-state", "get-serialno":
+		var value string
+		if value, err = c.query(c.deviceQueryPrefix() + command); err == nil {
+			fmt.Fprintln(stdout, value)
+		}
+	case "shell":
+		status, err = c.shell(strings.Join(args, " "), stdout, stderr)
+	case "logcat":
+		status, err = c.shell(strings.TrimSpace("exec logcat "+strings.Join(args, " ")), stdout, stderr)
+	case "exec-out":
+		err = c.exec(strings.Join(args, " "), stdout)
+	case "start-server", "kill-server":
+	default:
+		err = fmt.Errorf("unknown command %s", command)
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "adb: error: %v\n", err)
+		return 1
+	}
+	return status
+}
+
+// deviceQueryPrefix selects the device of a host query such as features
+func (c *client) deviceQueryPrefix() string {
+	switch {
+	case c.serial != "":
+		return "host-serial:" + c.serial + ":"
+	case c.transportID != "":
+		return "host-transport-id:" + c.transportID + ":"
+	default:
+		return "host-any:"
+	}
+}
+
+// transportRequest switches a connection to the selected device
+func (c *client) transportRequest() string {
+	switch {
+	case c.serial != "":
+		return "host:transport:" + c.serial
+	case c.transportID != "":
+		return "host:transport-id:" + c.transportID
+	default:
+		return "host:transport-any"
+	}
+}
+
+func (c *client) connect() (net.Conn, error) {
+	conn, err := net.Dial("tcp", c.address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to daemon at tcp:%s: %w", c.address, err)
+	}
+	return conn, nil
+}
+
+// query sends a host request and returns its length-prefixed answer
+func (c *client) query(service string) (string, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err := request(conn, service); err != nil {
+		return "", err
+	}
+	return readString(conn)
+}
+
+// openDevice connects to the selected device and starts service on it
+func (c *client) openDevice(service string) (net.Conn, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	if err := request(conn, c.transportRequest()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := request(conn, service); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// shell runs command with shell protocol v2 when the device supports it, so stderr and the exit code are kept
+func (c *client) shell(command string, stdout io.Writer, stderr io.Writer) (int, error) {
+	features, err := c.query(c.deviceQueryPrefix() + "features")
+	if err != nil {
+		return 1, err
+	}
+	if !strings.Contains(","+features+",", ",shell_v2,") {
+		conn, err := c.openDevice("shell:" + command)
+		if err != nil {
+			return 1, err
+		}
+		defer conn.Close()
+		io.Copy(stdout, conn)
+		return 0, nil
+	}
+
+	conn, err := c.openDevice("shell,v2,raw:" + command)
+	if err != nil {
+		return 1, err
+	}
+	defer conn.Close()
+
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return 1, fmt.Errorf("shell connection closed without an exit code")
+		}
+		data := make([]byte, binary.LittleEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return 1, err
+		}
+		switch header[0] {
+		case shellStdout:
+			stdout.Write(data)
+		case shellStderr:
+			stderr.Write(data)
+		case shellExit:
+			if len(data) == 0 {
+				return 1, nil
+			}
+			return int(data[0]), nil
+		}
+	}
+}
+
+// exec streams the raw stdout of command, as "adb exec-out" does
+func (c *client) exec(command string, stdout io.Writer) error {
+	conn, err := c.openDevice("exec:" + command)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = io.Copy(stdout, conn)
+	return err
+}
+
+// request sends a length-prefixed request and reads the OKAY or FAIL status
+func request(conn net.Conn, service string) error {
+	if _, err := fmt.Fprintf(conn, "%04x%s", len(service), service); err != nil {
+		return err
+	}
+	status := make([]byte, 4)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return fmt.Errorf("failed to read status: %w", err)
+	}
+	switch string(status) {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		message, err := readString(conn)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%s", message)
+	default:
+		return fmt.Errorf("unexpected status %q", status)
+	}
+}
+
+// readString reads four hex digits of length followed by that many bytes
+func readString(r io.Reader) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid length %q", header)
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	return string(data), err
+}
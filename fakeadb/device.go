@@ -0,0 +1,361 @@
+package fakeadb
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Device is a simulated device. Fields may be set before the device is added to a server;
+// afterwards use the methods, which are safe to call while clients are connected.
+type Device struct {
+	Serial string
+	// State is the adb state, e.g. "device", "offline" or "unauthorized"; empty means "device"
+	State      string
+	Properties map[string]string
+	// Screen is returned by screencap; its size is reported by "wm size"
+	Screen   image.Image
+	Density  int
+	Battery  int
+	UIDump   string
+	Packages []string
+	Logcat   []string
+	// Features are reported by "adb features"; nil means a modern device with shell v2
+	Features []string
+
+	mutex       sync.Mutex
+	transportID int
+	history     []string
+	logcatFeed  chan string
+}
+
+// DefaultFeatures are reported for devices that do not set Features
+var DefaultFeatures = []string{"shell_v2", "cmd", "stat_v2", "ls_v2", "fixed_push_mkdir", "apex", "abb", "abb_exec"}
+
+// NewEmulator returns an emulator running an Android 14 image with a small screen, a launcher UI dump and a few packages
+func NewEmulator(serial string, avdName string) *Device {
+	return &Device{
+		Serial: serial,
+		Properties: map[string]string{
+			"ro.product.model":                "sdk_gphone64_x86_64",
+			"ro.product.name":                 "sdk_gphone64_x86_64",
+			"ro.product.device":               "emu64x",
+			"ro.product.manufacturer":         "Google",
+			"ro.product.cpu.abi":              "x86_64",
+			"ro.product.cpu.abilist":          "x86_64,arm64-v8a",
+			"ro.build.version.release":        "14",
+			"ro.build.version.sdk":            "34",
+			"ro.build.version.security_patch": "2024-06-05",
+			"ro.build.fingerprint":            "google/sdk_gphone64_x86_64/emu64x:14/UE1A.230829.036/11228894:userdebug/dev-keys",
+			"ro.kernel.qemu":                  "1",
+			"ro.boot.qemu.avd_name":           avdName,
+			"persist.sys.locale":              "en-US",
+			"sys.boot_completed":              "1",
+		},
+		Screen:   image.NewRGBA(image.Rect(0, 0, 108, 240)),
+		Density:  420,
+		Battery:  100,
+		UIDump:   `<?xml version='1.0' encoding='UTF-8' standalone='yes' ?><hierarchy rotation="0"><node index="0" text="Settings" resource-id="" class="android.widget.TextView" package="com.google.android.apps.nexuslauncher" content-desc="Settings" clickable="true" bounds="[10,20][50,60]" /></hierarchy>`,
+		Packages: []string{"com.android.settings", "com.google.android.apps.nexuslauncher"},
+	}
+}
+
+func (d *Device) state() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.State == "" {
+		return "device"
+	}
+	return d.State
+}
+
+// SetState changes the adb state, e.g. to take the device offline
+func (d *Device) SetState(state string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.State = state
+}
+
+func (d *Device) features() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.Features == nil {
+		return DefaultFeatures
+	}
+	return d.Features
+}
+
+func (d *Device) supportsShellV2() bool {
+	for _, feature := range d.features() {
+		if feature == "shell_v2" {
+			return true
+		}
+	}
+	return false
+}
+
+// History returns the shell and exec commands the device has run, oldest first
+func (d *Device) History() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.history...)
+}
+
+// AppendLogcat adds log lines, which are also sent to clients following logcat
+func (d *Device) AppendLogcat(lines ...string) {
+	d.mutex.Lock()
+	d.Logcat = append(d.Logcat, lines...)
+	feed := d.logcatFeed
+	d.mutex.Unlock()
+
+	if feed != nil {
+		for _, line := range lines {
+			// A follower that cannot keep up misses lines rather than blocking the test
+			select {
+			case feed <- line:
+			default:
+			}
+		}
+	}
+}
+
+// devicesLine formats the device as "adb devices" does, with the -l attributes if long is set
+func (d *Device) devicesLine(long bool) string {
+	if !long {
+		return fmt.Sprintf("%s\t%s\n", d.Serial, d.state())
+	}
+
+	d.mutex.Lock()
+	attributes := []string{
+		"product:" + d.Properties["ro.product.name"],
+		"model:" + strings.ReplaceAll(d.Properties["ro.product.model"], " ", "_"),
+		"device:" + d.Properties["ro.product.device"],
+		"transport_id:" + strconv.Itoa(d.transportID),
+	}
+	d.mutex.Unlock()
+	return fmt.Sprintf("%-22s %s %s\n", d.Serial, d.state(), strings.Join(attributes, " "))
+}
+
+// run interprets a shell command line: simple commands separated by ";" or "&&", with quoting
+// and $? expansion, using the built-in programs below. Unknown programs fail with status 127.
+func (d *Device) run(command string, stdout io.Writer, stderr io.Writer, done <-chan struct{}) int {
+	d.mutex.Lock()
+	d.history = append(d.history, command)
+	d.mutex.Unlock()
+
+	status := 0
+	for _, part := range splitCommands(command) {
+		if part.andThen && status != 0 {
+			continue
+		}
+		words := splitWords(strings.ReplaceAll(part.text, "$?", strconv.Itoa(status)))
+		if len(words) == 0 {
+			continue
+		}
+		// "exec logcat" is how adb starts logcat on the device
+		if words[0] == "exec" && len(words) > 1 {
+			words = words[1:]
+		}
+		status = d.runProgram(words, stdout, stderr, done)
+	}
+	return status
+}
+
+func (d *Device) runProgram(words []string, stdout io.Writer, stderr io.Writer, done <-chan struct{}) int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	switch program, args := words[0], words[1:]; program {
+	case "echo":
+		fmt.Fprintln(stdout, strings.Join(args, " "))
+	case "true":
+	case "false":
+		return 1
+	case "getprop":
+		if len(args) > 0 {
+			fmt.Fprintln(stdout, d.Properties[args[0]])
+			break
+		}
+		keys := make([]string, 0, len(d.Properties))
+		for key := range d.Properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(stdout, "[%s]: [%s]\n", key, d.Properties[key])
+		}
+	case "wm":
+		switch {
+		case len(args) > 0 && args[0] == "size" && d.Screen != nil:
+			size := d.Screen.Bounds().Size()
+			fmt.Fprintf(stdout, "Physical size: %dx%d\n", size.X, size.Y)
+		case len(args) > 0 && args[0] == "density" && d.Density > 0:
+			fmt.Fprintf(stdout, "Physical density: %d\n", d.Density)
+		}
+	case "dumpsys":
+		if len(args) > 0 && args[0] == "battery" {
+			fmt.Fprintf(stdout, "Current Battery Service state:\n  AC powered: true\n  level: %d\n  scale: 100\n", d.Battery)
+		}
+	case "screencap":
+		if d.Screen == nil {
+			fmt.Fprintln(stderr, "screencap: no display")
+			return 1
+		}
+		var image bytes.Buffer
+		png.Encode(&image, d.Screen)
+		stdout.Write(image.Bytes())
+	case "uiautomator":
+		if len(args) < 1 || args[0] != "dump" || d.UIDump == "" {
+			fmt.Fprintln(stderr, "ERROR: could not get idle state.")
+			return 1
+		}
+		path := "/sdcard/window_dump.xml"
+		if len(args) > 1 {
+			path = args[1]
+		}
+		fmt.Fprint(stdout, d.UIDump)
+		fmt.Fprintf(stdout, "UI hierchary dumped to: %s\n", path)
+	case "pm":
+		if len(args) < 2 || args[0] != "list" || args[1] != "packages" {
+			fmt.Fprintln(stderr, "Unknown command: "+strings.Join(args, " "))
+			return 1
+		}
+		for _, name := range d.Packages {
+			fmt.Fprintln(stdout, "package:"+name)
+		}
+	case "input":
+		if len(args) == 0 {
+			fmt.Fprintln(stderr, "Usage: input [<source>] <command> [<arg>...]")
+			return 1
+		}
+	case "logcat":
+		return d.logcat(args, stdout, done)
+	default:
+		fmt.Fprintf(stderr, "/system/bin/sh: %s: inaccessible or not found\n", program)
+		return 127
+	}
+	return 0
+}
+
+// logcat prints the log, or its last -t lines; without -d it then follows new lines until the client goes away.
+// It is called with the device locked.
+func (d *Device) logcat(args []string, stdout io.Writer, done <-chan struct{}) int {
+	dump := false
+	lines := d.Logcat
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-c":
+			d.Logcat = nil
+			return 0
+		case "-d":
+			dump = true
+		case "-t":
+			if i+1 < len(args) {
+				if count, err := strconv.Atoi(args[i+1]); err == nil && count < len(lines) {
+					lines = lines[len(lines)-count:]
+				}
+				i++
+			}
+		}
+	}
+	for _, line := range lines {
+		fmt.Fprintln(stdout, line)
+	}
+	if dump {
+		return 0
+	}
+
+	feed := make(chan string, 16)
+	d.logcatFeed = feed
+	d.mutex.Unlock()
+	defer func() {
+		d.mutex.Lock()
+		if d.logcatFeed == feed {
+			d.logcatFeed = nil
+		}
+	}()
+	for {
+		select {
+		case line := <-feed:
+			fmt.Fprintln(stdout, line)
+		case <-done:
+			return 0
+		}
+	}
+}
+
+// commandPart is a simple command and whether it only runs after success ("&&")
+type commandPart struct {
+	text    string
+	andThen bool
+}
+
+// splitCommands splits a command line on unquoted ";" and "&&"
+func splitCommands(command string) []commandPart {
+	var parts []commandPart
+	var current strings.Builder
+	var quote rune
+	andThen := false
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ';' || (r == '&' && i+1 < len(runes) && runes[i+1] == '&'):
+			parts = append(parts, commandPart{text: current.String(), andThen: andThen})
+			current.Reset()
+			andThen = r == '&'
+			if andThen {
+				i++
+			}
+			continue
+		}
+		current.WriteRune(r)
+	}
+	return append(parts, commandPart{text: current.String(), andThen: andThen})
+}
+
+// splitWords splits a simple command into words, removing single and double quotes
+func splitWords(command string) []string {
+	var words []string
+	var current strings.Builder
+	var quote rune
+	inWord := false
+	for _, r := range command {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, current.String())
+	}
+	return words
+}
@@ -0,0 +1,113 @@
+package fakeadb
+
+import (
+	"bytes"
+	"image/png"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	emulator := NewEmulator("emulator-5554", "Pixel_8_API_34")
+	emulator.Logcat = []string{"first", "second", "third"}
+	legacy := &Device{Serial: "192.168.1.5:5555", Features: []string{"cmd"}, Properties: map[string]string{"ro.product.model": "Old Phone"}}
+	server, err := NewServer(emulator, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	adb := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		status := RunClient(append([]string{"-P", strconv.Itoa(server.Port())}, args...), &stdout, &stderr)
+		return status, stdout.String(), stderr.String()
+	}
+
+	t.Run("Devices", func(t *testing.T) {
+		status, stdout, _ := adb("devices", "-l")
+		want := "List of devices attached\n" +
+			"emulator-5554          device product:sdk_gphone64_x86_64 model:sdk_gphone64_x86_64 device:emu64x transport_id:1\n" +
+			"192.168.1.5:5555       device product: model:Old_Phone device: transport_id:2\n\n"
+		if status != 0 || stdout != want {
+			t.Errorf("status %d, got:\n%q\nwant:\n%q", status, stdout, want)
+		}
+	})
+
+	t.Run("ShellV2", func(t *testing.T) {
+		status, stdout, stderr := adb("-s", "emulator-5554", "shell", "getprop ro.build.version.sdk; missing")
+		if status != 127 || stdout != "34\n" || !strings.Contains(stderr, "missing: inaccessible or not found") {
+			t.Errorf("status %d, stdout %q, stderr %q", status, stdout, stderr)
+		}
+	})
+
+	t.Run("LegacyShell", func(t *testing.T) {
+		status, stdout, _ := adb("-s", "192.168.1.5:5555", "shell", `false; echo "exit $?"`)
+		if status != 0 || stdout != "exit 1\n" {
+			t.Errorf("status %d, stdout %q", status, stdout)
+		}
+	})
+
+	t.Run("ExecOut", func(t *testing.T) {
+		status, stdout, stderr := adb("-t", "1", "exec-out", "screencap", "-p")
+		if status != 0 {
+			t.Fatalf("status %d: %s", status, stderr)
+		}
+		image, err := png.Decode(strings.NewReader(stdout))
+		if err != nil || image.Bounds().Dx() != 108 {
+			t.Errorf("expected the emulator screen as PNG, got %v", err)
+		}
+	})
+
+	t.Run("Logcat", func(t *testing.T) {
+		if _, stdout, _ := adb("-s", "emulator-5554", "logcat", "-d", "-t", "2"); stdout != "second\nthird\n" {
+			t.Errorf("unexpected dump %q", stdout)
+		}
+
+		// Following logcat ends when the server drops the connection
+		output := make(chan string)
+		go func() {
+			_, stdout, _ := adb("-s", "emulator-5554", "logcat")
+			output <- stdout
+		}()
+		time.Sleep(200 * time.Millisecond)
+		emulator.AppendLogcat("fourth")
+		time.Sleep(200 * time.Millisecond)
+		server.Close()
+		if stdout := <-output; !strings.HasSuffix(stdout, "third\nfourth\n") {
+			t.Errorf("expected the appended line to be streamed, got %q", stdout)
+		}
+	})
+
+	if history := emulator.History(); len(history) == 0 || history[0] != "getprop ro.build.version.sdk; missing" {
+		t.Errorf("unexpected history %q", history)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	offline := NewEmulator("emulator-5556", "Offline")
+	offline.State = "offline"
+	server, err := NewServer(NewEmulator("emulator-5554", "Pixel"), offline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"-s", "emulator-5560", "shell", "true"}, "device 'emulator-5560' not found"},
+		{[]string{"-s", "emulator-5556", "exec-out", "true"}, "device offline"},
+		{[]string{"shell", "true"}, "more than one device/emulator"},
+		{[]string{"install", "app.apk"}, "unknown command install"},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		status := RunClient(append([]string{"-P", strconv.Itoa(server.Port())}, test.args...), &stdout, &stderr)
+		if status != 1 || !strings.Contains(stderr.String(), test.wantErr) {
+			t.Errorf("%v: expected %q, got status %d: %s", test.args, test.wantErr, status, stderr.String())
+		}
+	}
+}
@@ -0,0 +1,353 @@
+// Package fakeadb simulates an adb server for tests. It speaks the subset of the adb host protocol that RunClient
+// uses on a local TCP port, so tests can list and drive its simulated devices without an Android SDK.
+// It is only tested against RunClient, not against the real adb client.
+package fakeadb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// protocolVersion is the adb server version reported by host:version
+const protocolVersion = 41
+
+// Shell protocol v2 packet ids
+const (
+	shellStdin  = 0
+	shellStdout = 1
+	shellStderr = 2
+	shellExit   = 3
+)
+
+// Server is a fake adb server listening on a local TCP port
+type Server struct {
+	listener net.Listener
+
+	mutex           sync.Mutex
+	devices         []*Device
+	nextTransportID int
+	connections     map[net.Conn]bool
+	closed          bool
+}
+
+// NewServer starts a server on a free port of 127.0.0.1 with the given devices
+func NewServer(devices ...*Device) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	server := &Server{listener: listener, nextTransportID: 1, connections: make(map[net.Conn]bool)}
+	for _, device := range devices {
+		server.AddDevice(device)
+	}
+	go server.serve()
+	return server, nil
+}
+
+// Addr returns the host:port the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Port returns the port the server listens on, as passed to "adb -P"
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops the server and drops connected clients
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	for conn := range s.connections {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+	return s.listener.Close()
+}
+
+// AddDevice attaches a device, giving it the next transport id
+func (s *Server) AddDevice(device *Device) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	device.mutex.Lock()
+	device.transportID = s.nextTransportID
+	device.mutex.Unlock()
+	s.nextTransportID++
+	s.devices = append(s.devices, device)
+}
+
+// RemoveDevice detaches the device with serial, as if it was unplugged
+func (s *Server) RemoveDevice(serial string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, device := range s.devices {
+		if device.Serial == serial {
+			s.devices = append(s.devices[:i], s.devices[i+1:]...)
+			return
+		}
+	}
+}
+
+// Device returns the attached device with serial, or nil
+func (s *Server) Device(serial string) *Device {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, device := range s.devices {
+		if device.Serial == serial {
+			return device
+		}
+	}
+	return nil
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		s.connections[conn] = true
+		s.mutex.Unlock()
+
+		go func() {
+			defer func() {
+				s.mutex.Lock()
+				delete(s.connections, conn)
+				s.mutex.Unlock()
+				conn.Close()
+			}()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle answers host requests until the connection switches to a device transport or a request completes
+func (s *Server) handle(conn net.Conn) {
+	for {
+		request, err := readString(conn)
+		if err != nil {
+			return
+		}
+
+		switch {
+		case request == "host:version":
+			writeOkayString(conn, fmt.Sprintf("%04x", protocolVersion))
+		case request == "host:devices" || request == "host:devices-l":
+			var list strings.Builder
+			for _, device := range s.deviceList() {
+				list.WriteString(device.devicesLine(request == "host:devices-l"))
+			}
+			writeOkayString(conn, list.String())
+		case request == "host:features":
+			writeOkayString(conn, strings.Join(DefaultFeatures, ","))
+		case request == "host:kill":
+			writeOkay(conn)
+		case strings.HasPrefix(request, "host-serial:"), strings.HasPrefix(request, "host-transport-id:"), strings.HasPrefix(request, "host-any:"):
+			s.handleDeviceQuery(conn, request)
+		case strings.HasPrefix(request, "host:transport"), strings.HasPrefix(request, "host:tport:"):
+			device, err := s.transportDevice(request)
+			if err != nil {
+				writeFail(conn, err.Error())
+				return
+			}
+			writeOkay(conn)
+			if strings.HasPrefix(request, "host:tport:") {
+				binary.Write(conn, binary.LittleEndian, uint64(device.transportID))
+			}
+			s.handleDeviceService(conn, device)
+			return
+		default:
+			writeFail(conn, "unknown host service")
+			return
+		}
+	}
+}
+
+// handleDeviceQuery answers "host-serial:<serial>:<query>" and friends for features and get-state
+func (s *Server) handleDeviceQuery(conn net.Conn, request string) {
+	// Serials may contain colons, as in "192.168.1.5:5555", so the query is after the last one
+	index := strings.LastIndex(request, ":")
+	selector, query := request[:index], request[index+1:]
+
+	var device *Device
+	var err error
+	switch {
+	case strings.HasPrefix(selector, "host-serial:"):
+		device, err = s.findDevice(strings.TrimPrefix(selector, "host-serial:"), "", false)
+	case strings.HasPrefix(selector, "host-transport-id:"):
+		device, err = s.findDevice("", strings.TrimPrefix(selector, "host-transport-id:"), false)
+	default:
+		device, err = s.findDevice("", "", true)
+	}
+	if err != nil {
+		writeFail(conn, err.Error())
+		return
+	}
+
+	switch query {
+	case "features":
+		writeOkayString(conn, strings.Join(device.features(), ","))
+	case "get-state":
+		writeOkayString(conn, device.state())
+	case "get-serialno":
+		writeOkayString(conn, device.Serial)
+	default:
+		writeFail(conn, "unknown host service")
+	}
+}
+
+// transportDevice resolves the device of a host:transport or host:tport request
+func (s *Server) transportDevice(request string) (*Device, error) {
+	var device *Device
+	var err error
+	switch {
+	case strings.HasPrefix(request, "host:transport:"):
+		device, err = s.findDevice(strings.TrimPrefix(request, "host:transport:"), "", false)
+	case strings.HasPrefix(request, "host:tport:serial:"):
+		device, err = s.findDevice(strings.TrimPrefix(request, "host:tport:serial:"), "", false)
+	case strings.HasPrefix(request, "host:transport-id:"):
+		device, err = s.findDevice("", strings.TrimPrefix(request, "host:transport-id:"), false)
+	case strings.HasPrefix(request, "host:tport:transport-id:"):
+		device, err = s.findDevice("", strings.TrimPrefix(request, "host:tport:transport-id:"), false)
+	case request == "host:transport-any" || request == "host:tport:any":
+		device, err = s.findDevice("", "", true)
+	default:
+		return nil, fmt.Errorf("unknown host service")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch state := device.state(); state {
+	case "device":
+		return device, nil
+	case "unauthorized":
+		return nil, fmt.Errorf("device unauthorized.\nThis adb server's $ADB_VENDOR_KEYS is not set\nTry 'adb kill-server' if that seems wrong.\nOtherwise check for a confirmation dialog on your device.")
+	default:
+		return nil, fmt.Errorf("device %s", state)
+	}
+}
+
+func (s *Server) deviceList() []*Device {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*Device(nil), s.devices...)
+}
+
+// findDevice picks a device by serial, by transport id, or the only one when any is set
+func (s *Server) findDevice(serial string, transportID string, any bool) (*Device, error) {
+	devices := s.deviceList()
+	switch {
+	case any:
+		if len(devices) == 0 {
+			return nil, fmt.Errorf("no devices/emulators found")
+		}
+		if len(devices) > 1 {
+			return nil, fmt.Errorf("more than one device/emulator")
+		}
+		return devices[0], nil
+	case transportID != "":
+		id, _ := strconv.Atoi(transportID)
+		for _, device := range devices {
+			if device.transportID == id {
+				return device, nil
+			}
+		}
+		return nil, fmt.Errorf("no device with transport id '%s'", transportID)
+	default:
+		for _, device := range devices {
+			if device.Serial == serial {
+				return device, nil
+			}
+		}
+		return nil, fmt.Errorf("device '%s' not found", serial)
+	}
+}
+
+// handleDeviceService runs one shell or exec service on device and closes the stream when it ends
+func (s *Server) handleDeviceService(conn net.Conn, device *Device) {
+	request, err := readString(conn)
+	if err != nil {
+		return
+	}
+
+	// The client closing its end stops commands that run until interrupted, such as logcat
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(done)
+	}()
+
+	switch {
+	case strings.HasPrefix(request, "shell"):
+		options, command, found := strings.Cut(request, ":")
+		if !found {
+			writeFail(conn, "unknown device service")
+			return
+		}
+		writeOkay(conn)
+		if strings.Contains(options, "v2") && device.supportsShellV2() {
+			stdout := &packetWriter{conn: conn, id: shellStdout}
+			stderr := &packetWriter{conn: conn, id: shellStderr}
+			status := device.run(command, stdout, stderr, done)
+			writePacket(conn, shellExit, []byte{byte(status)})
+			return
+		}
+		// Without shell v2 stderr is merged into stdout and the exit code is lost
+		device.run(command, conn, conn, done)
+	case strings.HasPrefix(request, "exec:"):
+		writeOkay(conn)
+		device.run(strings.TrimPrefix(request, "exec:"), conn, io.Discard, done)
+	default:
+		writeFail(conn, "unknown device service")
+	}
+}
+
+// packetWriter frames output as shell protocol v2 packets
+type packetWriter struct {
+	conn io.Writer
+	id   byte
+}
+
+func (w *packetWriter) Write(data []byte) (int, error) {
+	if err := writePacket(w.conn, w.id, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func writePacket(w io.Writer, id byte, data []byte) error {
+	header := make([]byte, 5)
+	header[0] = id
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(data)))
+	if _, err := w.Write(append(header, data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func writeOkay(w io.Writer) {
+	io.WriteString(w, "OKAY")
+}
+
+func writeOkayString(w io.Writer, value string) {
+	io.WriteString(w, fmt.Sprintf("OKAY%04x%s", len(value), value))
+}
+
+func writeFail(w io.Writer, message string) {
+	io.WriteString(w, fmt.Sprintf("FAIL%04x%s", len(message), message))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortRule is an adb forward or reverse rule; Local is the socket on the host, Remote the socket on the device
+type PortRule struct {
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+}
+
+// socketSpecKinds are the socket kinds rules may use on either side
+var socketSpecKinds = []string{"tcp", "localabstract", "localfilesystem"}
+
+// validateSocketSpec checks a socket spec such as tcp:8080 or localabstract:chrome_devtools_remote;
+// allowAnyPort permits tcp:0 on the listening side, where adb picks a free port
+func validateSocketSpec(name string, spec string, allowAnyPort bool) error {
+	kind, value, found := strings.Cut(spec, ":")
+	if !found || !containsString(socketSpecKinds, kind) {
+		return fmt.Errorf("%s must be tcp:<port>, localabstract:<name> or localfilesystem:<path>, got %q", name, spec)
+	}
+	if value == "" || strings.ContainsAny(value, " \t\n") {
+		return fmt.Errorf("%s has an invalid %s name %q", name, kind, value)
+	}
+	if kind == "tcp" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 0 || port > 65535 || (port == 0 && !allowAnyPort) {
+			return fmt.Errorf("%s has an invalid tcp port %q", name, value)
+		}
+	}
+	return nil
+}
+
+// portRuleCommand names the adb command managing rules in one direction
+func portRuleCommand(reverse bool) string {
+	if reverse {
+		return "reverse"
+	}
+	return "forward"
+}
+
+// createPortRule adds a rule and returns it with tcp:0 replaced by the port adb allocated.
+// Forward rules listen on the host (local), reverse rules on the device (remote).
+func createPortRule(ctx context.Context, deviceName string, reverse bool, rule PortRule, noRebind bool) (PortRule, error) {
+	args := []string{"-s", deviceName, portRuleCommand(reverse)}
+	if noRebind {
+		args = append(args, "--no-rebind")
+	}
+	listener := &rule.Local
+	if reverse {
+		args = append(args, rule.Remote, rule.Local)
+		listener = &rule.Remote
+	} else {
+		args = append(args, rule.Local, rule.Remote)
+	}
+
+	cmd := adbCommand(ctx, args...)
+	output, err := outputWithTimeout(cmd, commandTimeout)
+	if err != nil {
+		return PortRule{}, fmt.Errorf("failed to %s %s to %s on device %s: %w%s", portRuleCommand(reverse), rule.Local, rule.Remote, deviceName, err, commandStderr(err))
+	}
+
+	// With tcp:0 adb prints the port it picked
+	if *listener == "tcp:0" {
+		port, err := strconv.Atoi(strings.TrimSpace(string(output)))
+		if err != nil {
+			return PortRule{}, fmt.Errorf("adb did not report the allocated port, output: %s", strings.TrimSpace(string(output)))
+		}
+		*listener = "tcp:" + strconv.Itoa(port)
+	}
+	return rule, nil
+}
+
+// listPortRules returns the device's rules in one direction
+func listPortRules(ctx context.Context, deviceName string, reverse bool) ([]PortRule, error) {
+	cmd := adbCommand(ctx, "-s", deviceName, portRuleCommand(reverse), "--list")
+	output, err := outputWithTimeout(cmd, commandTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s rules on device %s: %w%s", portRuleCommand(reverse), deviceName, err, commandStderr(err))
+	}
+	return parsePortRules(string(output), deviceSerial(deviceName), reverse), nil
+}
+
+// parsePortRules parses `adb forward --list`, whose lines are "<serial> <local> <remote>" for every device,
+// or `adb reverse --list`, whose lines are "<transport> <remote> <local>" for the device only
+func parsePortRules(output string, serial string, reverse bool) []PortRule {
+	rules := []PortRule{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		if reverse {
+			rules = append(rules, PortRule{Local: fields[2], Remote: fields[1]})
+		} else if fields[0] == serial {
+			rules = append(rules, PortRule{Local: fields[1], Remote: fields[2]})
+		}
+	}
+	return rules
+}
+
+// removePortRule removes the rule listening on spec, or every rule of the device in that direction when spec is empty
+func removePortRule(ctx context.Context, deviceName string, reverse bool, spec string) error {
+	if spec == "" && !reverse {
+		// forward --remove-all would drop the rules of every device, so remove this device's one by one
+		rules, err := listPortRules(ctx, deviceName, false)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			if err := removePortRule(ctx, deviceName, false, rule.Local); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	args := []string{"-s", deviceName, portRuleCommand(reverse), "--remove-all"}
+	if spec != "" {
+		args = []string{"-s", deviceName, portRuleCommand(reverse), "--remove", spec}
+	}
+
+	cmd := adbCommand(ctx, args...)
+	if _, err := outputWithTimeout(cmd, commandTimeout); err != nil {
+		return fmt.Errorf("failed to remove %s rules on device %s: %w%s", portRuleCommand(reverse), deviceName, err, commandStderr(err))
+	}
+	return nil
+}
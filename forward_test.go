@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateSocketSpec(t *testing.T) {
+	tests := []struct {
+		spec         string
+		allowAnyPort bool
+		wantErr      string
+	}{
+		{"tcp:8080", false, ""},
+		{"tcp:0", true, ""},
+		{"localabstract:chrome_devtools_remote", false, ""},
+		{"localfilesystem:/data/local/tmp/socket", false, ""},
+		{"tcp:0", false, "invalid tcp port"},
+		{"tcp:http", false, "invalid tcp port"},
+		{"jdwp:1234", false, "must be tcp:<port>"},
+		{"localabstract:", false, "invalid localabstract name"},
+		{"8080", false, "must be tcp:<port>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			err := validateSocketSpec("local", test.spec, test.allowAnyPort)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("expected error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParsePortRules(t *testing.T) {
+	forwards := "emulator-5554 tcp:9222 localabstract:chrome_devtools_remote\nemulator-5556 tcp:6000 tcp:6000\n"
+	if rules := parsePortRules(forwards, "emulator-5554", false); !reflect.DeepEqual(rules, []PortRule{{Local: "tcp:9222", Remote: "localabstract:chrome_devtools_remote"}}) {
+		t.Errorf("expected the rule of emulator-5554 only, got %+v", rules)
+	}
+	if rules := parsePortRules("host-19 tcp:8081 tcp:8080\n", "emulator-5554", true); !reflect.DeepEqual(rules, []PortRule{{Local: "tcp:8080", Remote: "tcp:8081"}}) {
+		t.Errorf("expected the device socket as remote, got %+v", rules)
+	}
+	if rules := parsePortRules("", "emulator-5554", false); len(rules) != 0 {
+		t.Errorf("expected no rules, got %+v", rules)
+	}
+}
+
+func TestPortRules(t *testing.T) {
+	useHelperDevices(t)
+	call := func(reverse bool, arguments map[string]interface{}) (ToolsCallResult, error) {
+		return handlePortRules(reverse)(&ToolCall{Arguments: arguments, Device: "emulator-5554"})
+	}
+
+	t.Run("ForwardAnyPort", func(t *testing.T) {
+		result, err := call(false, map[string]interface{}{"action": "create", "local": "tcp:0", "remote": "localabstract:chrome_devtools_remote"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := result.StructuredContent.(map[string]interface{})
+		if output["allocated_port"] != 41235 || !strings.Contains(result.Content[0].Text, "forward tcp:41235 to localabstract:chrome_devtools_remote") {
+			t.Errorf("expected the allocated port, got %v: %s", output, result.Content[0].Text)
+		}
+		if rules := output["rules"].([]PortRule); len(rules) != 1 || rules[0].Local != "tcp:9222" {
+			t.Errorf("expected the rules of the device, got %+v", rules)
+		}
+	})
+
+	t.Run("ReverseAnyPort", func(t *testing.T) {
+		result, err := call(true, map[string]interface{}{"action": "create", "local": "tcp:8080", "remote": "tcp:0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port := result.StructuredContent.(map[string]interface{})["allocated_port"]; port != 38291 {
+			t.Errorf("expected the port allocated on the device, got %v", port)
+		}
+	})
+
+	t.Run("Reverse", func(t *testing.T) {
+		result, err := call(true, map[string]interface{}{"action": "create", "local": "tcp:8080", "remote": "tcp:8080"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := result.StructuredContent.(map[string]interface{})
+		if _, ok := output["allocated_port"]; ok || !reflect.DeepEqual(output["rules"], []PortRule{{Local: "tcp:8080", Remote: "tcp:8080"}}) {
+			t.Errorf("unexpected output %v", output)
+		}
+	})
+
+	t.Run("RemoveAllForwards", func(t *testing.T) {
+		// Only the rule of emulator-5554 is removed; the helper fails for any other listener
+		if _, err := call(false, map[string]interface{}{"action": "remove"}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := call(false, map[string]interface{}{"action": "create", "local": "tcp:9222", "remote": "tcp:9222", "no_rebind": true}); err == nil || !strings.Contains(err.Error(), "cannot rebind existing socket") {
+			t.Errorf("expected adb's error, got %v", err)
+		}
+		if _, err := call(false, map[string]interface{}{"action": "remove", "local": "tcp:1234"}); err == nil || !strings.Contains(err.Error(), "listener 'tcp:1234' not found") {
+			t.Errorf("expected adb's error, got %v", err)
+		}
+		if _, err := call(true, map[string]interface{}{"action": "create", "local": "tcp:0", "remote": "tcp:8080"}); err == nil || !strings.Contains(err.Error(), "local has an invalid tcp port") {
+			t.Errorf("expected tcp:0 to be refused on the connecting side, got %v", err)
+		}
+	})
+}
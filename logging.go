@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// logLevels are the syslog severities used by MCP logging, from least to most severe
+var logLevels = []string{"debug", "info", "notice", "warning", "error", "critical", "alert", "emergency"}
+
+// defaultClientLogLevel applies until the client calls logging/setLevel
+const defaultClientLogLevel = "warning"
+
+// stderrLogLevel is the least severe level also written to stderr, so adb traces do not flood it
+const stderrLogLevel = "info"
+
+var clientLogLevel atomic.Int32
+
+func init() {
+	clientLogLevel.Store(int32(logLevelIndex(defaultClientLogLevel)))
+}
+
+func logLevelIndex(level string) int {
+	for i, name := range logLevels {
+		if name == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// logEvent reports data to the client as notifications/message when level is at or above the client's level,
+// and to stderr from stderrLogLevel up
+func logEvent(level string, logger string, data interface{}) {
+	index := logLevelIndex(level)
+	if index >= logLevelIndex(stderrLogLevel) {
+		dataJSON, _ := json.Marshal(data)
+		log.Printf("[%s] %s: %s", level, logger, dataJSON)
+	}
+	if index >= int(clientLogLevel.Load()) {
+		sendNotification("notifications/message", LoggingMessageParams{
+			Level:  level,
+			Logger: logger,
+			Data:   data,
+		})
+	}
+}
+
+// logMessage reports a formatted message through logEvent
+func logMessage(level string, logger string, format string, args ...interface{}) {
+	logEvent(level, logger, map[string]interface{}{
+		"message": fmt.Sprintf(format, args...),
+	})
+}
+
+// traceCommand reports a finished command with its exit code and duration at debug level,
+// redacting the argv secrets the audit log redacts
+func traceCommand(cmd *exec.Cmd, started time.Time, err error) {
+	if int(clientLogLevel.Load()) > logLevelIndex("debug") {
+		return
+	}
+
+	data := map[string]interface{}{
+		"argv":        redactArgv(cmd.Args),
+		"duration_ms": time.Since(started).Milliseconds(),
+	}
+	if cmd.ProcessState != nil {
+		data["exit_code"] = cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	logEvent("debug", "exec", data)
+}
+
+func handleLoggingSetLevel(request JSONRPCRequest) {
+	var params LoggingSetLevelParams
+	if request.Params != nil {
+		paramsBytes, _ := json.Marshal(request.Params)
+		if err := json.Unmarshal(paramsBytes, &params); err != nil {
+			sendError(request.ID, -32602, "Invalid params", nil)
+			return
+		}
+	}
+
+	index := logLevelIndex(params.Level)
+	if index < 0 {
+		sendError(request.ID, -32602, "Invalid params", map[string]interface{}{
+			"error": "level must be one of: " + strings.Join(logLevels, ", "),
+		})
+		return
+	}
+	clientLogLevel.Store(int32(index))
+
+	sendResponse(JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  map[string]interface{}{},
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLogging(t *testing.T) {
+	var response JSONRPCResponse
+	var messages []LoggingMessageParams
+	originalSendResponse := sendResponse
+	sendResponse = func(resp JSONRPCResponse) { response = resp }
+	originalSendNotification := sendNotification
+	sendNotification = func(method string, params interface{}) {
+		if method == "notifications/message" {
+			messages = append(messages, params.(LoggingMessageParams))
+		}
+	}
+	originalLevel := clientLogLevel.Load()
+	defer func() {
+		sendResponse = originalSendResponse
+		sendNotification = originalSendNotification
+		clientLogLevel.Store(originalLevel)
+	}()
+
+	setLevel := func(level string) {
+		handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "logging/setLevel", Params: map[string]interface{}{"level": level}})
+	}
+
+	setLevel("verbose")
+	if response.Error == nil || response.Error.Code != -32602 {
+		t.Fatalf("expected invalid params for an unknown level, got %+v", response)
+	}
+
+	setLevel("warning")
+	if response.Error != nil {
+		t.Fatalf("unexpected error: %+v", response.Error)
+	}
+	logMessage("info", "test", "not sent")
+	logMessage("error", "test", "device %s vanished", "emulator-5554")
+	runWithTimeout(helperCommand("adb", "devices"), commandTimeout)
+	if len(messages) != 1 || messages[0].Level != "error" || messages[0].Logger != "test" {
+		t.Fatalf("expected only the error message, got %+v", messages)
+	}
+	if data := messages[0].Data.(map[string]interface{}); data["message"] != "device emulator-5554 vanished" {
+		t.Errorf("unexpected message data: %v", data)
+	}
+
+	messages = nil
+	setLevel("debug")
+	runWithTimeout(helperCommand("adb", "devices"), commandTimeout)
+	if len(messages) != 1 || messages[0].Level != "debug" || messages[0].Logger != "exec" {
+		t.Fatalf("expected a command trace, got %+v", messages)
+	}
+	data := messages[0].Data.(map[string]interface{})
+	argv := data["argv"].([]string)
+	if argv[len(argv)-2] != "adb" || argv[len(argv)-1] != "devices" || data["exit_code"] != 0 {
+		t.Errorf("unexpected trace data: %v", data)
+	}
+
+	// The pairing code is redacted as in the audit log
+	messages = nil
+	runWithTimeout(helperCommand("adb", "pair", "192.168.1.5:40001", "123456"), commandTimeout)
+	if len(messages) != 1 {
+		t.Fatalf("expected a command trace, got %+v", messages)
+	}
+	argv = messages[0].Data.(map[string]interface{})["argv"].([]string)
+	if got := strings.Join(argv[len(argv)-4:], " "); got != "adb pair 192.168.1.5:40001 [REDACTED]" {
+		t.Errorf("expected the pairing code to be redacted, got %q", got)
+	}
+}
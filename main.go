@@ -1,43 +1,125 @@
 package main
 
 import (
-	"bufio"
-	"encoding/base64"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 var execCommand = exec.Command
 var lookPath = exec.LookPath
+
+// deviceDetailsWorkers bounds how many devices are queried concurrently when listing devices
+var deviceDetailsWorkers = 8
+
+// commandTimeout bounds quick adb and emulator commands so a wedged adb server cannot hang a request
+var commandTimeout = 30 * time.Second
+
+// commandWaitDelay bounds how long a finished or killed command may keep its output pipes open
+var commandWaitDelay = 2 * time.Second
+
+// deviceDetailsTimeout keeps one hung device from stalling the device listing
+var deviceDetailsTimeout = 10 * time.Second
+
+// supportedProtocolVersions lists the MCP revisions this server speaks, newest first
+var supportedProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// protocolVersion is the revision negotiated during initialize
+var protocolVersion = "2024-11-05"
+
+// outputMutex keeps responses and notifications from interleaving on stdout
+var outputMutex sync.Mutex
+
 var sendResponse = func(response JSONRPCResponse) {
 	responseBytes, _ := json.Marshal(response)
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
 	fmt.Println(string(responseBytes))
 }
 
+var sendNotification = func(method string, params interface{}) {
+	notificationBytes, _ := json.Marshal(JSONRPCNotification{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+	})
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	fmt.Println(string(notificationBytes))
+}
+
 func main() {
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
+	// A binary re-run by a transcript replayer only prints the recorded output
+	if status, ok := replayedProcess(); ok {
+		os.Exit(status)
+	}
+
+	configFlag := flag.String("config", "", "path to the JSON config file (default $"+configEnvVar+")")
+	recordFlag := flag.String("record", "", "record every adb and emulator command with its output to this transcript file")
+	replayFlag := flag.String("replay", "", "answer adb and emulator commands from this transcript file instead of running them")
+	flag.Usage = func() {
+		printUsage(os.Stderr)
+		fmt.Fprintln(os.Stderr)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if path := configPath(*configFlag); path != "" {
+		config, err := loadConfig(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		setConfig(config)
+		if flag.NArg() == 0 {
+			go watchConfig(path, nil)
 		}
+	}
 
-		var request JSONRPCRequest
-		if err := json.Unmarshal([]byte(line), &request); err != nil {
-			sendError(nil, -32700, "Parse error", nil)
-			continue
+	switch {
+	case *recordFlag != "" && *replayFlag != "":
+		log.Fatal("-record and -replay cannot be used together")
+	case *recordFlag != "":
+		if err := startRecording(*recordFlag); err != nil {
+			log.Fatal(err)
+		}
+	case *replayFlag != "":
+		if err := startReplay(*replayFlag); err != nil {
+			log.Fatal(err)
 		}
+	}
+
+	// Subcommands print to stdout themselves, so log messages must not become JSON-RPC notifications there
+	if flag.NArg() > 0 {
+		sendNotification = func(method string, params interface{}) {}
+	}
 
-		handleRequest(request)
+	// Stop child processes on SIGTERM or Ctrl+C as well as when the client closes stdin
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		os.Exit(shutdown("received " + received.String()))
+	}()
+
+	if flag.NArg() > 0 {
+		os.Exit(runCLI(flag.Args(), os.Stdout, os.Stderr))
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal("Error reading from stdin:", err)
+	if err := serve(os.Stdin); err != nil {
+		logMessage("error", "server", "%v", err)
 	}
+	os.Exit(shutdown("stdin closed"))
 }
 
 func handleRequest(request JSONRPCRequest) {
@@ -48,21 +130,48 @@ func handleRequest(request JSONRPCRequest) {
 		handleToolsList(request)
 	case "tools/call":
 		handleToolsCall(request)
+	case "resources/list":
+		handleResourcesList(request)
+	case "resources/templates/list":
+		handleResourceTemplatesList(request)
+	case "resources/read":
+		handleResourcesRead(request)
+	case "prompts/list":
+		handlePromptsList(request)
+	case "prompts/get":
+		handlePromptsGet(request)
+	case "logging/setLevel":
+		handleLoggingSetLevel(request)
 	default:
 		sendError(request.ID, -32601, "Method not found", nil)
 	}
 }
 
 func handleInitialize(request JSONRPCRequest) {
+	var params InitializeParams
+	if request.Params != nil {
+		paramsBytes, _ := json.Marshal(request.Params)
+		if err := json.Unmarshal(paramsBytes, &params); err != nil {
+			sendError(request.ID, -32602, "Invalid params", nil)
+			return
+		}
+	}
+
+	protocolVersion = negotiateProtocolVersion(params.ProtocolVersion)
+	clientInfo = params.ClientInfo
+
 	response := JSONRPCResponse{
 		JSONRPC: "2.0",
 		ID:      request.ID,
 		Result: InitializeResult{
-			ProtocolVersion: "2024-11-05",
+			ProtocolVersion: protocolVersion,
 			Capabilities: ServerCapabilities{
 				Tools: &ToolsCapability{
 					ListChanged: true,
 				},
+				Resources: &ResourcesCapability{},
+				Prompts:   &PromptsCapability{},
+				Logging:   &LoggingCapability{},
 			},
 			ServerInfo: ServerInfo{
 				Name:    "android-devices-mcp-server",
@@ -73,36 +182,32 @@ func handleInitialize(request JSONRPCRequest) {
 	sendResponse(response)
 }
 
-func handleToolsList(request JSONRPCRequest) {
-	tools := []Tool{
-		{
-			Name:        "get_android_devices",
-			Description: "Get a list of connected Android devices and emulators",
-			InputSchema: map[string]interface{}{
-				"type":       "object",
-				"properties": map[string]interface{}{},
-			},
-		},
-		{
-			Name:        "get_android_screen",
-			Description: "Capture a screenshot from an Android device",
-			InputSchema: map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"device": map[string]interface{}{
-						"type":        "string",
-						"description": "Device name/serial (e.g., 'emulator-5554'). If not provided, uses the first available device.",
-					},
-				},
-			},
-		},
+// negotiateProtocolVersion echoes the client's version when supported, otherwise offers the latest one
+func negotiateProtocolVersion(requested string) string {
+	for _, version := range supportedProtocolVersions {
+		if version == requested {
+			return version
+		}
 	}
+	return supportedProtocolVersions[0]
+}
 
+// structuredOutputSupported reports whether the negotiated revision knows outputSchema and structuredContent
+func structuredOutputSupported() bool {
+	return protocolVersion >= "2025-06-18"
+}
+
+// toolAnnotationsSupported reports whether the negotiated protocol version includes tool annotations
+func toolAnnotationsSupported() bool {
+	return protocolVersion >= "2025-03-26"
+}
+
+func handleToolsList(request JSONRPCRequest) {
 	response := JSONRPCResponse{
 		JSONRPC: "2.0",
 		ID:      request.ID,
 		Result: ToolsListResult{
-			Tools: tools,
+			Tools: toolRegistry.Tools(),
 		},
 	}
 	sendResponse(response)
@@ -118,96 +223,7 @@ func handleToolsCall(request JSONRPCRequest) {
 		}
 	}
 
-	switch params.Name {
-	case "get_android_devices":
-		handleGetDevices(request, params)
-	case "get_android_screen":
-		handleGetScreen(request, params)
-	default:
-		sendError(request.ID, -32602, "Unknown tool: "+params.Name, nil)
-	}
-}
-
-func handleGetDevices(request JSONRPCRequest, params ToolsCallParams) {
-	devices, err := getDeviceList()
-	if err != nil {
-		sendError(request.ID, -32603, "Internal error", map[string]interface{}{
-			"error": err.Error(),
-		})
-		return
-	}
-
-	devicesJSON, _ := json.Marshal(devices)
-	response := JSONRPCResponse{
-		JSONRPC: "2.0",
-		ID:      request.ID,
-		Result: ToolsCallResult{
-			Content: []ContentItem{
-				{
-					Type: "text",
-					Text: string(devicesJSON),
-				},
-			},
-			IsError: false,
-		},
-	}
-	sendResponse(response)
-}
-
-func handleGetScreen(request JSONRPCRequest, params ToolsCallParams) {
-	deviceName := ""
-	if params.Arguments != nil {
-		if device, exists := params.Arguments["device"]; exists {
-			if deviceStr, ok := device.(string); ok {
-				deviceName = deviceStr
-			}
-		}
-	}
-
-	// If no device specified, use the first available device
-	if deviceName == "" {
-		devices, err := getDeviceList()
-		if err != nil {
-			sendError(request.ID, -32603, "Internal error", map[string]interface{}{
-				"error": "Failed to get device list: " + err.Error(),
-			})
-			return
-		}
-
-		if len(devices) == 0 {
-			sendError(request.ID, -32603, "Internal error", map[string]interface{}{
-				"error": "No Android devices found",
-			})
-			return
-		}
-
-		deviceName = devices[0].Device
-	}
-
-	// Capture screenshot
-	base64Data, err := captureScreenshot(deviceName)
-	if err != nil {
-		sendError(request.ID, -32603, "Internal error", map[string]interface{}{
-			"error": err.Error(),
-		})
-		return
-	}
-
-	response := JSONRPCResponse{
-		JSONRPC: "2.0",
-		ID:      request.ID,
-		Result: ToolsCallResult{
-			Content: []ContentItem{
-				{
-					Type:     "image",
-					Data:     base64Data,
-					MimeType: "image/png",
-				},
-			},
-			IsError: false,
-		},
-	}
-	sendResponse(response)
+	toolRegistry.Call(request, params)
 }
 
 func sendError(id interface{}, code int, message string, data interface{}) {
@@ -223,15 +239,68 @@ func sendError(id interface{}, code int, message string, data interface{}) {
 	sendResponse(response)
 }
 
-func getDeviceList() ([]Device, error) {
+func getDeviceList(ctx context.Context) ([]Device, error) {
+	devices, err := listAdbDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fetchDeviceDetails(ctx, devices)
+	return devices, nil
+}
+
+// listAdbDevices parses `adb devices -l` without querying the devices themselves
+func listAdbDevices(ctx context.Context) ([]Device, error) {
 	// Check if adb command exists
-	_, err := lookPath("adb")
+	_, err := lookPath(currentConfig().adbBinary())
 	if err != nil {
 		return nil, fmt.Errorf("adb command not found: %w", err)
 	}
 
-	cmd := execCommand("adb", "devices", "-l")
-	output, err := cmd.CombinedOutput()
+	servers := currentConfig().adbServers()
+	listings := make([][]Device, len(servers))
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server adbServer) {
+			defer wg.Done()
+			listings[i], errs[i] = listServerDevices(ctx, server)
+		}(i, server)
+	}
+	wg.Wait()
+
+	// A lab machine that is down should not hide the devices of the others
+	var devices []Device
+	var failures []string
+	byHost := make(map[string]adbServer, len(servers))
+	for i, server := range servers {
+		if errs[i] != nil {
+			if len(servers) == 1 {
+				return nil, errs[i]
+			}
+			logMessage("warning", "adb", "Failed to list devices of adb server %s: %v", server, errs[i])
+			failures = append(failures, fmt.Sprintf("%s: %v", server, errs[i]))
+			continue
+		}
+		byHost[server.String()] = server
+		for _, device := range listings[i] {
+			device.Host = server.String()
+			devices = append(devices, device)
+		}
+	}
+	if len(failures) == len(servers) {
+		return nil, fmt.Errorf("no adb server could be reached: %s", strings.Join(failures, "; "))
+	}
+
+	setDeviceRoutes(devices, byHost)
+	return devices, nil
+}
+
+// listServerDevices runs "adb devices -l" against one server
+func listServerDevices(ctx context.Context, server adbServer) ([]Device, error) {
+	cmd := adbServerCommand(ctx, server, "devices", "-l")
+	output, err := runWithTimeout(cmd, commandTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("error running adb command: %w, output: %s", err, string(output))
 	}
@@ -240,7 +309,8 @@ func getDeviceList() ([]Device, error) {
 	var devices []Device
 
 	for _, line := range lines {
-		if strings.HasPrefix(line, "List of devices") || line == "" {
+		// Skip the header and daemon status lines such as "* daemon started successfully"
+		if strings.HasPrefix(line, "List of devices") || strings.HasPrefix(line, "*") || line == "" {
 			continue
 		}
 
@@ -249,106 +319,265 @@ func getDeviceList() ([]Device, error) {
 			continue
 		}
 
-		device := Device{
-			Device:    parts[0],
-			RunStatus: parts[1],
+		// Remaining fields are attributes such as "usb:1-1", "product:sdk_gphone_x86" and "transport_id:1"
+		attributes := make(map[string]string)
+		for _, part := range parts[2:] {
+			if key, value, found := strings.Cut(part, ":"); found {
+				attributes[key] = value
+			}
 		}
 
-		// Get Android Version, SDK Level, Model, Arch and Brand
-		name, androidVersion, sdkLevel, model, arch, err := getDeviceDetails(device.Device)
-		if err != nil {
-			log.Printf("Failed to get details for device %s: %v", device.Device, err)
+		devices = append(devices, Device{
+			Device:         parts[0],
+			RunStatus:      parts[1],
+			TransportID:    attributes["transport_id"],
+			ConnectionType: deviceConnectionType(parts[0]),
+		})
+	}
+
+	return devices, nil
+}
+
+// fetchDeviceDetails fills in device details concurrently, bounded so a large device farm does not spawn too many adb processes
+func fetchDeviceDetails(ctx context.Context, devices []Device) {
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, deviceDetailsWorkers)
+	for i := range devices {
+		// Offline and unauthorized devices cannot answer getprop
+		if devices[i].RunStatus != "device" {
+			continue
 		}
-		device.Name = name
-		device.AndroidVersion = androidVersion
-		device.SDKLevel = sdkLevel
-		device.Model = model
-		device.Arch = arch
 
-		devices = append(devices, device)
+		wg.Add(1)
+		go func(device *Device) {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+
+			info, err := getDeviceInfo(ctx, device.qualifiedName())
+			if err != nil {
+				logMessage("warning", "devices", "Failed to get details for device %s: %v", device.Device, err)
+				return
+			}
+			applyDeviceInfo(device, info)
+		}(&devices[i])
 	}
+	wg.Wait()
+}
 
-	return devices, nil
+// deviceInfoSeparator splits the sections of deviceInfoCommand's output
+const deviceInfoSeparator = "--- mcp-android-devices ---"
+
+// deviceInfoCommand gathers properties, screen metrics and battery state in a single shell round-trip
+var deviceInfoCommand = "getprop; echo '" + deviceInfoSeparator + "'; wm size; wm density; echo '" + deviceInfoSeparator + "'; dumpsys battery"
+
+// deviceInfo is the raw state read from a device by getDeviceInfo
+type deviceInfo struct {
+	Properties map[string]string
+	Screen     map[string]string
+	Battery    map[string]string
 }
 
-func getDeviceDetails(deviceName string) (string, string, string, string, string, error) {
-	// Check if the device is an emulator
-	isEmulatorCmd := execCommand("adb", "-s", deviceName, "shell", "getprop", "ro.kernel.qemu")
-	isEmulatorOutput, err := isEmulatorCmd.CombinedOutput()
+// getDeviceInfo reads all system properties, screen metrics and battery state of a device with one shell command
+func getDeviceInfo(ctx context.Context, deviceName string) (deviceInfo, error) {
+	cmd := adbCommand(ctx, "-s", deviceName, "shell", deviceInfoCommand)
+	output, err := runWithTimeout(cmd, currentConfig().deviceDetailsTimeout())
 	if err != nil {
-		return "", "", "", "", "", fmt.Errorf("failed to check if device is an emulator: %w, output: %s", err, string(isEmulatorOutput))
+		return deviceInfo{}, fmt.Errorf("failed to read device properties: %w, output: %s", err, string(output))
 	}
-	isEmulator := strings.TrimSpace(string(isEmulatorOutput)) == "1"
 
-	var name string
-	if isEmulator {
-		avdNameCmd := execCommand("adb", "-s", deviceName, "shell", "getprop", "ro.boot.qemu.avd_name")
-		avdNameOutput, err := avdNameCmd.CombinedOutput()
-		if err != nil {
-			log.Printf("failed to get avd name for device %s: %v", deviceName, err)
-		} else {
-			name = strings.ReplaceAll(strings.TrimSpace(string(avdNameOutput)), "_", " ")
+	sections := strings.SplitN(strings.ReplaceAll(string(output), "\r\n", "\n"), deviceInfoSeparator, 3)
+	for len(sections) < 3 {
+		sections = append(sections, "")
+	}
+
+	return deviceInfo{
+		Properties: parseGetprop(sections[0]),
+		Screen:     parseKeyValueLines(sections[1]),
+		Battery:    parseKeyValueLines(sections[2]),
+	}, nil
+}
+
+// parseKeyValueLines parses "Key: value" lines as printed by wm and dumpsys
+func parseKeyValueLines(output string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found {
+			continue
 		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
 	}
+	return values
+}
 
-	if name == "" {
-		brandCmd := execCommand("adb", "-s", deviceName, "shell", "getprop", "ro.product.brand")
-		brandOutput, err := brandCmd.CombinedOutput()
-		if err != nil {
-			return "", "", "", "", "", fmt.Errorf("failed to get device brand: %w, output: %s", err, string(brandOutput))
+// parseGetprop parses "getprop" output lines of the form "[key]: [value]"
+func parseGetprop(output string) map[string]string {
+	properties := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		key, value, found := strings.Cut(line, "]: [")
+		if !found || !strings.HasPrefix(key, "[") || !strings.HasSuffix(value, "]") {
+			continue
 		}
-		brand := strings.TrimSpace(string(brandOutput))
+		properties[strings.TrimPrefix(key, "[")] = strings.TrimSuffix(value, "]")
+	}
+	return properties
+}
 
-		modelCmd := execCommand("adb", "-s", deviceName, "shell", "getprop", "ro.product.model")
-		modelOutput, err := modelCmd.CombinedOutput()
-		if err != nil {
-			return "", "", "", "", "", fmt.Errorf("failed to get device model: %w, output: %s", err, string(modelOutput))
+func applyDeviceInfo(device *Device, info deviceInfo) {
+	properties := info.Properties
+
+	// Emulators are named after their AVD, physical devices after brand and model
+	if properties["ro.kernel.qemu"] == "1" {
+		avdName := properties["ro.boot.qemu.avd_name"]
+		if avdName == "" {
+			avdName = properties["ro.kernel.qemu.avd_name"]
 		}
-		model := strings.TrimSpace(string(modelOutput))
-		name = fmt.Sprintf("%s %s", brand, model)
+		device.Name = strings.ReplaceAll(avdName, "_", " ")
+	}
+	if device.Name == "" {
+		device.Name = fmt.Sprintf("%s %s", properties["ro.product.brand"], properties["ro.product.model"])
 	}
 
-	androidVersionCmd := execCommand("adb", "-s", deviceName, "shell", "getprop", "ro.build.version.release")
-	androidVersionOutput, err := androidVersionCmd.CombinedOutput()
-	if err != nil {
-		return "", "", "", "", "", fmt.Errorf("failed to get android version: %w, output: %s", err, string(androidVersionOutput))
+	device.AndroidVersion = properties["ro.build.version.release"]
+	device.SDKLevel = properties["ro.build.version.sdk"]
+	device.Model = properties["ro.product.model"]
+	device.Arch = properties["ro.product.cpu.abi"]
+	device.Manufacturer = properties["ro.product.manufacturer"]
+	device.Fingerprint = properties["ro.build.fingerprint"]
+	device.SecurityPatch = properties["ro.build.version.security_patch"]
+	device.BootCompleted = properties["sys.boot_completed"] == "1"
+
+	device.Locale = properties["persist.sys.locale"]
+	if device.Locale == "" {
+		device.Locale = properties["ro.product.locale"]
 	}
-	androidVersion := strings.TrimSpace(string(androidVersionOutput))
 
-	sdkLevelCmd := execCommand("adb", "-s", deviceName, "shell", "getprop", "ro.build.version.sdk")
-	sdkLevelOutput, err := sdkLevelCmd.CombinedOutput()
-	if err != nil {
-		return "", "", "", "", "", fmt.Errorf("failed to get sdk level: %w, output: %s", err, string(sdkLevelOutput))
+	if abiList := properties["ro.product.cpu.abilist"]; abiList != "" {
+		device.ABIs = strings.Split(abiList, ",")
 	}
-	sdkLevel := strings.TrimSpace(string(sdkLevelOutput))
 
-	modelCmd := execCommand("adb", "-s", deviceName, "shell", "getprop", "ro.product.model")
-	modelOutput, err := modelCmd.CombinedOutput()
-	if err != nil {
-		return "", "", "", "", "", fmt.Errorf("failed to get device model: %w, output: %s", err, string(modelOutput))
+	// An override set with `wm size`/`wm density` is what apps actually see
+	device.ScreenSize = info.Screen["Override size"]
+	if device.ScreenSize == "" {
+		device.ScreenSize = info.Screen["Physical size"]
+	}
+	device.ScreenDensity = info.Screen["Override density"]
+	if device.ScreenDensity == "" {
+		device.ScreenDensity = info.Screen["Physical density"]
 	}
-	model := strings.TrimSpace(string(modelOutput))
 
-	archCmd := execCommand("adb", "-s", deviceName, "shell", "getprop", "ro.product.cpu.abi")
-	archOutput, err := archCmd.CombinedOutput()
-	if err != nil {
-		return "", "", "", "", "", fmt.Errorf("failed to get device architecture: %w, output: %s", err, string(archOutput))
+	device.BatteryLevel = info.Battery["level"]
+}
+
+// filterDeviceFields reduces each device to the requested JSON fields, always keeping the serial
+func filterDeviceFields(devices []Device, fields []string) []map[string]interface{} {
+	keep := map[string]bool{"device": true}
+	for _, field := range fields {
+		keep[field] = true
 	}
-	arch := strings.TrimSpace(string(archOutput))
 
-	return name, androidVersion, sdkLevel, model, arch, nil
+	filtered := make([]map[string]interface{}, 0, len(devices))
+	for _, device := range devices {
+		var values map[string]interface{}
+		deviceJSON, _ := json.Marshal(device)
+		json.Unmarshal(deviceJSON, &values)
+
+		for key := range values {
+			if !keep[key] {
+				delete(values, key)
+			}
+		}
+		filtered = append(filtered, values)
+	}
+	return filtered
 }
 
-func captureScreenshot(deviceName string) (string, error) {
-	// Use exec-out to stream screenshot data directly from device to PC
-	// This avoids creating temporary files on the Android device
-	screenshotCmd := execCommand("adb", "-s", deviceName, "exec-out", "screencap", "-p")
-	imageData, err := screenshotCmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to capture screenshot from device %s: %w", deviceName, err)
+// runWithTimeout runs cmd like cmd.CombinedOutput, killing it if it does not finish within timeout
+func runWithTimeout(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := runWithDeadline(cmd, timeout)
+	return output.Bytes(), err
+}
+
+// outputWithTimeout runs cmd like cmd.Output, killing it if it does not finish within timeout
+func outputWithTimeout(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := runWithDeadline(cmd, timeout)
+
+	// Keep stderr available to commandStderr as cmd.Output would
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), err
+}
+
+// runWithDeadline runs cmd with its configured output writers, killing it if it does not finish within timeout
+func runWithDeadline(cmd *exec.Cmd, timeout time.Duration) (err error) {
+	started := time.Now()
+	defer func() { traceCommand(cmd, started, err) }()
+	if recorder := activeRecorder.Load(); recorder != nil {
+		defer recorder.capture(cmd)()
+	}
+
+	// A child that forked, such as adb starting its server, may keep the output pipes open after the kill
+	if cmd.WaitDelay == 0 {
+		cmd.WaitDelay = commandWaitDelay
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer trackProcess(cmd, strings.Join(cmd.Args, " "))()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// adbCommand builds an adb invocation using the configured adb binary. Commands for a device given with -s
+// go to the server the device is attached to, others to the first configured server. The command is recorded
+// in the audit log of the tool call carried by ctx.
+func adbCommand(ctx context.Context, args ...string) *exec.Cmd {
+	server := currentConfig().adbServers()[0]
+	if len(args) >= 2 && args[0] == "-s" {
+		var serial string
+		serial, server = deviceServer(args[1])
+		args = append([]string{"-s", serial}, args[2:]...)
 	}
+	return adbServerCommand(ctx, server, args...)
+}
 
-	// Encode to base64
-	base64Data := base64.StdEncoding.EncodeToString(imageData)
-	return base64Data, nil
+// adbServerCommand builds an adb invocation against a specific server
+func adbServerCommand(ctx context.Context, server adbServer, args ...string) *exec.Cmd {
+	cmd := execCommand(currentConfig().adbBinary(), append(server.args(), args...)...)
+	recordAuditCommand(ctx, cmd)
+	return cmd
+}
+
+// commandStderr returns the stderr captured by cmd.Output() as an error message suffix
+func commandStderr(err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return ", stderr: " + strings.TrimSpace(string(exitErr.Stderr))
+	}
+	return ""
 }
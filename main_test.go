@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"reflect"
-	"runtime"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestMCPInitialize(t *testing.T) {
@@ -63,6 +70,80 @@ func TestMCPInitialize(t *testing.T) {
 	}
 }
 
+func TestMCPStructuredOutput(t *testing.T) {
+	originalExecCommand := execCommand
+	execCommand = helperCommand
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) {
+		return file, nil
+	}
+	var response JSONRPCResponse
+	originalSendResponse := sendResponse
+	sendResponse = func(resp JSONRPCResponse) {
+		response = resp
+	}
+	originalProtocolVersion := protocolVersion
+	defer func() {
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+		sendResponse = originalSendResponse
+		protocolVersion = originalProtocolVersion
+	}()
+
+	initialize := func(version string) string {
+		handleRequest(JSONRPCRequest{
+			JSONRPC: "2.0",
+			ID:      1,
+			Method:  "initialize",
+			Params:  map[string]interface{}{"protocolVersion": version},
+		})
+		return response.Result.(InitializeResult).ProtocolVersion
+	}
+
+	if version := initialize("1999-01-01"); version != supportedProtocolVersions[0] {
+		t.Errorf("expected unsupported version to negotiate %s, got %s", supportedProtocolVersions[0], version)
+	}
+
+	if version := initialize("2024-11-05"); version != "2024-11-05" {
+		t.Fatalf("expected 2024-11-05, got %s", version)
+	}
+	handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 2, Method: "tools/list"})
+	if response.Result.(ToolsListResult).Tools[0].OutputSchema != nil {
+		t.Error("expected no outputSchema for 2024-11-05 clients")
+	}
+
+	if version := initialize("2025-06-18"); version != "2025-06-18" {
+		t.Fatalf("expected 2025-06-18, got %s", version)
+	}
+	handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 3, Method: "tools/list"})
+	outputSchema := response.Result.(ToolsListResult).Tools[0].OutputSchema
+	if outputSchema == nil {
+		t.Fatal("expected outputSchema for 2025-06-18 clients")
+	}
+
+	handleRequest(JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      4,
+		Method:  "tools/call",
+		Params:  map[string]interface{}{"name": "get_android_devices"},
+	})
+	result := response.Result.(ToolsCallResult)
+	if result.IsError {
+		t.Fatalf("unexpected tool error: %+v", result.Content)
+	}
+
+	// Round-trip through JSON as a client would see it before checking against the schema
+	var structured interface{}
+	structuredJSON, _ := json.Marshal(result.StructuredContent)
+	json.Unmarshal(structuredJSON, &structured)
+	if err := validateSchema(outputSchema, structured, ""); err != nil {
+		t.Errorf("structuredContent does not match outputSchema: %v", err)
+	}
+	if !strings.Contains(string(structuredJSON), `"device":"emulator-5554"`) {
+		t.Errorf("unexpected structuredContent: %s", structuredJSON)
+	}
+}
+
 func TestMCPToolsList(t *testing.T) {
 	request := JSONRPCRequest{
 		JSONRPC: "2.0",
@@ -94,8 +175,8 @@ func TestMCPToolsList(t *testing.T) {
 		t.Fatal("expected ToolsListResult")
 	}
 
-	if len(result.Tools) != 2 {
-		t.Errorf("expected 2 tools, got %d", len(result.Tools))
+	if len(result.Tools) != 15 {
+		t.Errorf("expected 15 tools, got %d", len(result.Tools))
 	}
 
 	// Check first tool
@@ -115,13 +196,12 @@ func TestMCPToolsCall(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
 		// Mock the exec.Command function
 		originalExecCommand := execCommand
-		execCommand = func(command string, args ...string) *exec.Cmd {
-			cs := []string{"-test.run=TestHelperProcess", "--", command}
-			cs = append(cs, args...)
-			cmd := exec.Command(os.Args[0], cs...)
-			cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
-			return cmd
+		execCommand = helperCommand
+		originalLookPath := lookPath
+		lookPath = func(file string) (string, error) {
+			return file, nil
 		}
+		defer func() { lookPath = originalLookPath }()
 
 		request := JSONRPCRequest{
 			JSONRPC: "2.0",
@@ -190,6 +270,18 @@ func TestMCPToolsCall(t *testing.T) {
 			AndroidVersion: "11",
 			SDKLevel:       "30",
 			RunStatus:      "device",
+			TransportID:    "1",
+			Host:           "localhost:5037",
+			ConnectionType: "emulator",
+			Manufacturer:   "Google",
+			Fingerprint:    "google/sdk_gphone_x86/generic_x86_arm:11/RSR1.201013.001/6903271:userdebug/dev-keys",
+			SecurityPatch:  "2020-10-05",
+			ScreenSize:     "1080x1920",
+			ScreenDensity:  "420",
+			BatteryLevel:   "100",
+			Locale:         "en-US",
+			BootCompleted:  true,
+			ABIs:           []string{"x86", "armeabi-v7a", "armeabi"},
 		}
 
 		if !reflect.DeepEqual(devices[0], expectedDevice) {
@@ -197,6 +289,44 @@ func TestMCPToolsCall(t *testing.T) {
 		}
 	})
 
+	t.Run("Fields", func(t *testing.T) {
+		originalExecCommand := execCommand
+		execCommand = helperCommand
+		originalLookPath := lookPath
+		lookPath = func(file string) (string, error) {
+			return file, nil
+		}
+		var response JSONRPCResponse
+		originalSendResponse := sendResponse
+		sendResponse = func(resp JSONRPCResponse) {
+			response = resp
+		}
+		defer func() {
+			execCommand = originalExecCommand
+			lookPath = originalLookPath
+			sendResponse = originalSendResponse
+		}()
+
+		handleRequest(JSONRPCRequest{
+			JSONRPC: "2.0",
+			ID:      3,
+			Method:  "tools/call",
+			Params: map[string]interface{}{
+				"name":      "get_android_devices",
+				"arguments": map[string]interface{}{"fields": []interface{}{"sdk_level", "battery_level"}},
+			},
+		})
+
+		result, ok := response.Result.(ToolsCallResult)
+		if !ok {
+			t.Fatalf("expected ToolsCallResult, got %+v", response)
+		}
+		expected := `[{"battery_level":"100","device":"emulator-5554","sdk_level":"30"}]`
+		if result.Content[0].Text != expected {
+			t.Errorf("unexpected filtered devices: got %s want %s", result.Content[0].Text, expected)
+		}
+	})
+
 	t.Run("UnknownTool", func(t *testing.T) {
 		request := JSONRPCRequest{
 			JSONRPC: "2.0",
@@ -244,7 +374,7 @@ func TestGetDeviceList(t *testing.T) {
 			return "", fmt.Errorf("adb not found")
 		}
 
-		_, err := getDeviceList()
+		_, err := getDeviceList(context.Background())
 		if err == nil {
 			t.Error("expected an error, but got nil")
 		}
@@ -258,6 +388,285 @@ func TestGetDeviceList(t *testing.T) {
 	})
 }
 
+func TestGetDeviceListHungDevice(t *testing.T) {
+	originalExecCommand := execCommand
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		cmd := helperCommand(command, args...)
+		cmd.Env = append(cmd.Env, "HELPER_HUNG_DEVICE=emulator-5556")
+		return cmd
+	}
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) {
+		return file, nil
+	}
+	originalTimeout := deviceDetailsTimeout
+	deviceDetailsTimeout = 2 * time.Second
+	defer func() {
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+		deviceDetailsTimeout = originalTimeout
+	}()
+
+	start := time.Now()
+	devices, err := getDeviceList(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hung device stalled the listing for %s", elapsed)
+	}
+
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(devices))
+	}
+	if devices[0].Name != "Pixel 2 API 30" || devices[0].SDKLevel != "30" {
+		t.Errorf("expected details for the responsive device, got %+v", devices[0])
+	}
+	if devices[1].Device != "emulator-5556" || devices[1].RunStatus != "device" || devices[1].Model != "" {
+		t.Errorf("expected the hung device without details, got %+v", devices[1])
+	}
+}
+
+func TestDeviceConnectionType(t *testing.T) {
+	tests := []struct {
+		serial string
+		want   string
+	}{
+		{"emulator-5554", "emulator"},
+		{"192.168.1.20:5555", "tcp"},
+		{"adb-R58M123ABC-xyz._adb-tls-connect._tcp", "tcp"},
+		{"R58M123ABC", "usb"},
+	}
+	for _, test := range tests {
+		if got := deviceConnectionType(test.serial); got != test.want {
+			t.Errorf("deviceConnectionType(%q) = %q, want %q", test.serial, got, test.want)
+		}
+	}
+}
+
+func TestParseGetprop(t *testing.T) {
+	properties := parseGetprop("[ro.product.model]: [Pixel 7]\r\n[ro.build.fingerprint]: [google/panther:14/UP1A]\n[persist.empty]: []\nnot a property\n")
+	expected := map[string]string{
+		"ro.product.model":     "Pixel 7",
+		"ro.build.fingerprint": "google/panther:14/UP1A",
+		"persist.empty":        "",
+	}
+	if !reflect.DeepEqual(properties, expected) {
+		t.Errorf("unexpected properties: got %v want %v", properties, expected)
+	}
+}
+
+// stopStartedEmulator kills an emulator a test started and waits until it is no longer tracked
+func stopStartedEmulator(t *testing.T, deviceName string) {
+	startedEmulatorsMutex.Lock()
+	cmd := startedEmulators[deviceName]
+	startedEmulatorsMutex.Unlock()
+	if cmd == nil {
+		return
+	}
+
+	cmd.Process.Kill()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		processMutex.Lock()
+		_, tracked := trackedProcesses[cmd]
+		processMutex.Unlock()
+		if !tracked {
+			return
+		}
+	}
+	t.Errorf("emulator %s is still tracked after it was killed", deviceName)
+}
+
+func TestRunWithTimeoutOrphan(t *testing.T) {
+	originalWaitDelay := commandWaitDelay
+	commandWaitDelay = 100 * time.Millisecond
+	defer func() { commandWaitDelay = originalWaitDelay }()
+
+	started := time.Now()
+	_, err := runWithTimeout(helperCommand("orphan"), 500*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("expected the kill to return despite the grandchild holding stdout, took %s", elapsed)
+	}
+}
+
+func TestFindFreeEmulatorPort(t *testing.T) {
+	useHelperDevices(t, "emulator-5554\tdevice")
+
+	// Something outside adb holds the adb port of 5556, like an emulator that has not registered yet
+	listener, err := net.Listen("tcp", "127.0.0.1:5557")
+	if err != nil {
+		t.Skipf("port 5557 is in use: %v", err)
+	}
+	defer listener.Close()
+
+	port, err := findFreeEmulatorPort(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port < 5558 || port%2 != 0 {
+		t.Errorf("expected a port after 5556, got %d", port)
+	}
+}
+
+func TestStartEmulatorProgress(t *testing.T) {
+	originalExecCommand := execCommand
+	execCommand = helperCommand
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) { return file, nil }
+	var response JSONRPCResponse
+	originalSendResponse := sendResponse
+	sendResponse = func(resp JSONRPCResponse) { response = resp }
+	var progress []ProgressParams
+	originalSendNotification := sendNotification
+	sendNotification = func(method string, params interface{}) {
+		if method == "notifications/progress" {
+			progress = append(progress, params.(ProgressParams))
+		}
+	}
+	defer func() {
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+		sendResponse = originalSendResponse
+		sendNotification = originalSendNotification
+	}()
+
+	t.Cleanup(func() { stopStartedEmulator(t, "emulator-5556") })
+
+	toolRegistry.Call(JSONRPCRequest{ID: 1}, ToolsCallParams{
+		Name:      "android_start_emulator",
+		Arguments: map[string]interface{}{"avd_name": "Pixel_6_API_33"},
+		Meta:      &RequestMeta{ProgressToken: "boot-1"},
+	})
+
+	result, ok := response.Result.(ToolsCallResult)
+	if !ok || result.IsError || result.Content[0].Text != "Emulator Pixel_6_API_33 booted as emulator-5556" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	expected := []ProgressParams{
+		{ProgressToken: "boot-1", Progress: 5, Total: 100, Message: "Started emulator for AVD Pixel_6_API_33 as emulator-5556"},
+		{ProgressToken: "boot-1", Progress: 100, Total: 100, Message: "Emulator emulator-5556 booted"},
+	}
+	if !reflect.DeepEqual(progress, expected) {
+		t.Errorf("expected progress %+v, got %+v", expected, progress)
+	}
+}
+
+func TestStartEmulatorBootTimeout(t *testing.T) {
+	originalExecCommand := execCommand
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		cmd := helperCommand(command, args...)
+		cmd.Env = append(cmd.Env, "HELPER_NOT_BOOTED=1")
+		return cmd
+	}
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) { return file, nil }
+	originalPollInterval := bootPollInterval
+	bootPollInterval = 10 * time.Millisecond
+	defer func() {
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+		bootPollInterval = originalPollInterval
+	}()
+
+	_, err := startEmulator(context.Background(), "Pixel_6_API_33", EmulatorStartOptions{BootTimeout: 200 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "did not finish booting") {
+		t.Fatalf("expected a boot timeout, got %v", err)
+	}
+
+	// The emulator that did not boot is stopped rather than left running
+	startedEmulatorsMutex.Lock()
+	_, running := startedEmulators["emulator-5556"]
+	startedEmulatorsMutex.Unlock()
+	processMutex.Lock()
+	var tracked bool
+	for _, process := range trackedProcesses {
+		tracked = tracked || process.description == "emulator emulator-5556"
+	}
+	processMutex.Unlock()
+	if running || tracked {
+		t.Errorf("expected the emulator to be stopped, still running: %v, still tracked: %v", running, tracked)
+	}
+}
+
+func TestGetAVDList(t *testing.T) {
+	originalExecCommand := execCommand
+	execCommand = helperCommand
+	defer func() { execCommand = originalExecCommand }()
+
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) {
+		return file, nil
+	}
+	defer func() { lookPath = originalLookPath }()
+
+	avdHome := t.TempDir()
+	t.Setenv("ANDROID_AVD_HOME", avdHome)
+	avdPath := filepath.Join(avdHome, "Pixel_6_API_33.avd")
+	if err := os.MkdirAll(avdPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	rootIni := "avd.ini.encoding=UTF-8\npath=" + avdPath + "\ntarget=android-33\n"
+	if err := os.WriteFile(filepath.Join(avdHome, "Pixel_6_API_33.ini"), []byte(rootIni), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configIni := "avd.ini.displayname=Pixel 6 API 33\nabi.type=x86_64\nimage.sysdir.1=system-images/android-33/google_apis/x86_64/\nskin.name=pixel_6\n"
+	if err := os.WriteFile(filepath.Join(avdPath, "config.ini"), []byte(configIni), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	avds, err := getAVDList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []AVD{
+		{
+			Name:        "Pixel_6_API_33",
+			DisplayName: "Pixel 6 API 33",
+			Target:      "android-33",
+			APILevel:    "33",
+			ABI:         "x86_64",
+			Skin:        "pixel_6",
+			Path:        avdPath,
+		},
+		{
+			Name: "Wear_OS",
+		},
+	}
+	if !reflect.DeepEqual(avds, expected) {
+		t.Errorf("unexpected AVDs: got %+v want %+v", avds, expected)
+	}
+}
+
+func helperCommand(command string, args ...string) *exec.Cmd {
+	cs := []string{"-test.run=TestHelperProcess", "--", command}
+	cs = append(cs, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
+	return cmd
+}
+
+// helperDeviceProperties are the system properties reported by the fake emulator-5554
+var helperDeviceProperties = map[string]string{
+	"ro.build.version.release":        "11",
+	"ro.build.version.sdk":            "30",
+	"ro.product.model":                "sdk_gphone_x86",
+	"ro.product.cpu.abi":              "x86",
+	"ro.product.brand":                "Google",
+	"ro.kernel.qemu":                  "1",
+	"ro.boot.qemu.avd_name":           "Pixel_2_API_30",
+	"ro.product.manufacturer":         "Google",
+	"ro.build.fingerprint":            "google/sdk_gphone_x86/generic_x86_arm:11/RSR1.201013.001/6903271:userdebug/dev-keys",
+	"ro.build.version.security_patch": "2020-10-05",
+	"ro.product.cpu.abilist":          "x86,armeabi-v7a,armeabi",
+	"persist.sys.locale":              "en-US",
+	"sys.boot_completed":              "1",
+}
+
 func TestHelperProcess(t *testing.T) {
 	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
 		return
@@ -267,111 +676,168 @@ func TestHelperProcess(t *testing.T) {
 	cmd, args := args[0], args[1:]
 
 	switch cmd {
+	case "emulator":
+		switch args[0] {
+		case "-list-avds":
+			fmt.Println("INFO    | Storing crashdata in: /tmp/android-user/emu-crash.db")
+			fmt.Println("Pixel_6_API_33")
+			fmt.Println("Wear_OS")
+		case "-avd":
+			// Stay running like an emulator would while the test polls for boot completion
+			time.Sleep(5 * time.Second)
+		}
 	case "adb":
 		switch args[0] {
 		case "devices":
 			fmt.Println("List of devices attached")
-			fmt.Println(`emulator-5554	device`)
+			if devices := os.Getenv("HELPER_DEVICES"); devices != "" {
+				fmt.Println(devices)
+				break
+			}
+			fmt.Println(`emulator-5554	device product:sdk_gphone_x86 model:sdk_gphone_x86 device:generic_x86 transport_id:1`)
+			if hung := os.Getenv("HELPER_HUNG_DEVICE"); hung != "" {
+				fmt.Println(hung + "\tdevice")
+			}
+		case "pair":
+			if args[2] != "123456" {
+				fmt.Println("Failed: Wrong password or connection was dropped.")
+				break
+			}
+			fmt.Printf("Successfully paired to %s [guid=adb-R5CT1234-AbCdEf]\n", args[1])
+		case "connect":
+			if strings.HasPrefix(args[1], "192.168.1.99:") {
+				fmt.Printf("failed to connect to '%s': Connection refused\n", args[1])
+				os.Exit(1)
+			}
+			fmt.Printf("connected to %s\n", args[1])
+		case "disconnect":
+			if len(args) == 1 {
+				fmt.Println("disconnected everything")
+			} else if args[1] == "192.168.1.5:5555" {
+				fmt.Printf("disconnected %s\n", args[1])
+			} else {
+				fmt.Printf("error: no such device '%s'\n", args[1])
+				os.Exit(1)
+			}
+		case "mdns":
+			fmt.Println("List of discovered mdns services")
+			fmt.Println("adb-R5CT1234-AbCdEf\t_adb-tls-connect._tcp\t192.168.1.5:37123")
+			fmt.Println("adb-R5CT1234-AbCdEf\t_adb-tls-pairing._tcp.\t192.168.1.5:40001")
 		case "-s":
+			if args[1] == os.Getenv("HELPER_HUNG_DEVICE") {
+				time.Sleep(30 * time.Second)
+			}
 			switch args[2] {
+			case "exec-out":
+				switch args[3] {
+				case "uiautomator":
+					fmt.Print(`<?xml version='1.0' encoding='UTF-8' standalone='yes' ?><hierarchy rotation="0"><node text="Settings" /></hierarchy>`)
+					fmt.Println("UI hierchary dumped to: /dev/tty")
+				case "screencap":
+					png.Encode(os.Stdout, image.NewRGBA(image.Rect(0, 0, 4, 2)))
+				}
+			case "forward":
+				switch args[3] {
+				case "--list":
+					fmt.Println("emulator-5554 tcp:9222 localabstract:chrome_devtools_remote")
+					fmt.Println("emulator-5556 tcp:6000 tcp:6000")
+				case "--no-rebind":
+					fmt.Fprintln(os.Stderr, "adb: error: cannot rebind existing socket")
+					os.Exit(1)
+				case "--remove":
+					if args[4] != "tcp:9222" {
+						fmt.Fprintf(os.Stderr, "adb: error: listener '%s' not found\n", args[4])
+						os.Exit(1)
+					}
+				case "tcp:0":
+					fmt.Println("41235")
+				}
+			case "reverse":
+				switch args[3] {
+				case "--list":
+					fmt.Println("UsbFfs tcp:8080 tcp:8080")
+				case "tcp:0":
+					fmt.Println("38291")
+				}
+			case "tcpip":
+				fmt.Printf("restarting in TCP mode port: %s\n", args[3])
+			case "features":
+				if features, ok := os.LookupEnv("HELPER_FEATURES"); ok {
+					fmt.Println(features)
+				} else {
+					fmt.Println("shell_v2,cmd,stat_v2")
+				}
 			case "shell":
 				switch args[3] {
+				case "fail":
+					// shell v2 keeps stderr separate and forwards the exit code
+					fmt.Println("out")
+					fmt.Fprintln(os.Stderr, "err")
+					os.Exit(3)
+				case legacyShellCommand("fail"):
+					// Legacy shells merge stderr into stdout and always exit 0
+					fmt.Println("out")
+					fmt.Println("err")
+					fmt.Println(shellExitMarker + "3")
+				case legacyShellCommand("offline"):
+					fmt.Fprintln(os.Stderr, "error: device offline")
+					os.Exit(1)
+				case legacyShellCommand("disconnect"):
+					// The connection dropped before the marker was printed
+					fmt.Println("out")
+				case "pm":
+					fmt.Println("package:com.google.android.youtube")
+					fmt.Println("package:com.android.settings")
+				case "yes":
+					fmt.Print(strings.Repeat("y\n", 500))
+				case "ip -f inet addr show wlan0":
+					fmt.Println("30: wlan0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc mq state UP group default qlen 1000")
+					fmt.Println("    inet 192.168.1.5/24 brd 192.168.1.255 scope global wlan0")
+				case "input tap 10 20":
+				case "input tap 0 0":
+					fmt.Fprintln(os.Stderr, "input: no display")
+					os.Exit(1)
+				case deviceInfoCommand:
+					for key, value := range helperDeviceProperties {
+						fmt.Printf("[%s]: [%s]\n", key, value)
+					}
+					fmt.Println(deviceInfoSeparator)
+					fmt.Println("Physical size: 1080x1920")
+					fmt.Println("Physical density: 420")
+					fmt.Println(deviceInfoSeparator)
+					fmt.Println("Current Battery Service state:")
+					fmt.Println("  AC powered: true")
+					fmt.Println("  level: 100")
 				case "getprop":
-					switch args[4] {
-					case "ro.build.version.release":
-						fmt.Println("11")
-					case "ro.build.version.sdk":
-						fmt.Println("30")
-					case "ro.product.model":
-						fmt.Println("sdk_gphone_x86")
-					case "ro.product.cpu.abi":
-						fmt.Println("x86")
-					case "ro.product.brand":
-						fmt.Println("Google")
-					case "ro.kernel.qemu":
-						fmt.Println("1")
-					case "ro.boot.qemu.avd_name":
-						fmt.Println("Pixel_2_API_30")
+					// Without a property name getprop dumps every property
+					if len(args) == 5 && args[4] == "sys.boot_completed" && os.Getenv("HELPER_NOT_BOOTED") == "1" {
+						break
 					}
-				}
-			}
-		}
-	}
-	os.Exit(0)
-}
-
-func TestFindEmulatorProcess(t *testing.T) {
-	devices, err := getDeviceList()
-	if err != nil {
-		t.Fatalf("Failed to get device list: %v", err)
-	}
-
-	if len(devices) == 0 {
-		t.Skip("No devices found, skipping test.")
-	}
-
-	// Get process list using platform-specific commands
-	var psOutput []byte
-	switch runtime.GOOS {
-	case "windows":
-		// Use tasklist on Windows
-		psOutput, err = exec.Command("tasklist", "/fo", "csv").Output()
-		if err != nil {
-			t.Fatalf("Failed to run 'tasklist': %v", err)
-		}
-	case "linux", "darwin":
-		// Use ps on Unix-like systems (Linux, macOS)
-		psOutput, err = exec.Command("ps", "aux").Output()
-		if err != nil {
-			t.Fatalf("Failed to run 'ps aux': %v", err)
-		}
-	default:
-		// Fallback for other Unix-like systems
-		psOutput, err = exec.Command("ps", "aux").Output()
-		if err != nil {
-			t.Fatalf("Failed to run 'ps aux' on %s: %v", runtime.GOOS, err)
-		}
-	}
-
-	foundProcess := false
-	for _, device := range devices {
-		// We are looking for emulator processes, which have a non-empty model
-		if device.Model != "" {
-			avdName := strings.ReplaceAll(device.Name, " ", "_")
-			avdArg := "-avd " + avdName
-			lines := strings.Split(string(psOutput), "\n")
-			for _, line := range lines {
-				// Check for emulator process with AVD name
-				if strings.Contains(line, "emulator") && strings.Contains(line, avdArg) {
-					t.Logf("Found process for AVD: %s", device.Name)
-					t.Logf("Process details: %s", line)
-					foundProcess = true
-
-					// Take a screenshot
-					screenshotPath := "/sdcard/screenshot.png"
-					screenshotCmd := exec.Command("adb", "-s", device.Device, "shell", "screencap", screenshotPath)
-					if err := screenshotCmd.Run(); err != nil {
-						t.Logf("Failed to take screenshot: %v", err)
-					} else {
-						// Pull the screenshot from the device
-						pullCmd := exec.Command("adb", "-s", device.Device, "pull", screenshotPath)
-						if err := pullCmd.Run(); err != nil {
-							t.Logf("Failed to pull screenshot: %v", err)
-						} else {
-							t.Logf("Screenshot saved to screenshot.png")
-						}
-						// Clean up the screenshot from the device
-						rmCmd := exec.Command("adb", "-s", device.Device, "shell", "rm", screenshotPath)
-						if err := rmCmd.Run(); err != nil {
-							t.Logf("Failed to remove screenshot from device: %v", err)
+					if len(args) == 4 {
+						for key, value := range helperDeviceProperties {
+							fmt.Printf("[%s]: [%s]\n", key, value)
 						}
+					} else {
+						fmt.Println(helperDeviceProperties[args[4]])
+					}
+				default:
+					if strings.HasPrefix(args[3], "logcat") {
+						fmt.Println("10-18 12:00:00.000  1000  1000 I ActivityManager: " + args[3])
 					}
 				}
 			}
 		}
+	case "sleep":
+		time.Sleep(30 * time.Second)
+	case "orphan":
+		// A grandchild keeps stdout open after this process is killed
+		grandchild := helperCommand("sleep")
+		grandchild.Stdout = os.Stdout
+		grandchild.Start()
+		time.Sleep(30 * time.Second)
+	case "ignore-sigterm":
+		signal.Ignore(syscall.SIGTERM)
+		time.Sleep(30 * time.Second)
 	}
-
-	if !foundProcess {
-		t.Error("Could not find a running emulator process.")
-	}
+	os.Exit(0)
 }
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ToolSafety classifies what a tool can do to a device; the zero value is the most conservative class
+type ToolSafety int
+
+const (
+	// SafetyDestructive tools can lose data or stop a device
+	SafetyDestructive ToolSafety = iota
+	// SafetyInteractive tools change device state in a recoverable way
+	SafetyInteractive
+	// SafetyReadOnly tools only read device or host state
+	SafetyReadOnly
+)
+
+var toolSafetyNames = map[ToolSafety]string{
+	SafetyDestructive: "destructive",
+	SafetyInteractive: "interactive",
+	SafetyReadOnly:    "read_only",
+}
+
+func (s ToolSafety) String() string {
+	return toolSafetyNames[s]
+}
+
+func parseToolSafety(name string) (ToolSafety, bool) {
+	for safety, safetyName := range toolSafetyNames {
+		if safetyName == name {
+			return safety, true
+		}
+	}
+	return 0, false
+}
+
+// defaultBlockedCommands match device commands that are refused unless the policy allows blocked commands
+var defaultBlockedCommands = []string{
+	`\brm\s+(-\S+\s+)*-\S*[rR]`,
+	`\breboot\b`,
+	`\b(shutdown|poweroff)\b`,
+	`\bwipe`,
+	`\bmkfs`,
+	`\bdd\b.*\bof=/dev/`,
+	`\brecovery\s+--wipe`,
+}
+
+var defaultBlockedPatterns = compileBlockedCommands(defaultBlockedCommands)
+
+func compileBlockedCommands(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		compiled[i] = regexp.MustCompile(pattern)
+	}
+	return compiled
+}
+
+// PolicyConfig restricts which tools, devices, packages and commands tool calls may use.
+// Device and package lists accept path.Match patterns such as "emulator-*" or "com.example.*".
+type PolicyConfig struct {
+	MaxToolSafety        string   `json:"max_tool_safety,omitempty"`
+	AllowedDevices       []string `json:"allowed_devices,omitempty"`
+	DeniedDevices        []string `json:"denied_devices,omitempty"`
+	AllowedPackages      []string `json:"allowed_packages,omitempty"`
+	DeniedPackages       []string `json:"denied_packages,omitempty"`
+	BlockedCommands      []string `json:"blocked_commands,omitempty"`
+	AllowBlockedCommands bool     `json:"allow_blocked_commands,omitempty"`
+
+	// blockedPatterns holds BlockedCommands compiled by Validate
+	blockedPatterns []*regexp.Regexp
+}
+
+// policyError is returned when the safety policy refuses a tool call
+type policyError struct {
+	reason string
+}
+
+func (e *policyError) Error() string {
+	return "blocked by safety policy: " + e.reason
+}
+
+// Validate reports the first invalid pattern or safety class in the policy and compiles blocked_commands
+func (p *PolicyConfig) Validate() error {
+	if p.MaxToolSafety != "" {
+		if _, ok := parseToolSafety(p.MaxToolSafety); !ok {
+			return fmt.Errorf("policy.max_tool_safety must be read_only, interactive or destructive, got %q", p.MaxToolSafety)
+		}
+	}
+
+	lists := map[string][]string{
+		"allowed_devices":  p.AllowedDevices,
+		"denied_devices":   p.DeniedDevices,
+		"allowed_packages": p.AllowedPackages,
+		"denied_packages":  p.DeniedPackages,
+	}
+	for name, patterns := range lists {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("policy.%s: invalid pattern %q: %w", name, pattern, err)
+			}
+		}
+	}
+
+	p.blockedPatterns = nil
+	for _, pattern := range p.BlockedCommands {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("policy.blocked_commands: %w", err)
+		}
+		p.blockedPatterns = append(p.blockedPatterns, compiled)
+	}
+	return nil
+}
+
+// checkTool refuses calls whose safety class exceeds the configured maximum
+func (p *PolicyConfig) checkTool(definition ToolDefinition, arguments map[string]interface{}) error {
+	if p.MaxToolSafety == "" {
+		return nil
+	}
+	maxSafety, _ := parseToolSafety(p.MaxToolSafety)
+	safety := definition.Safety
+	if definition.CallSafety != nil {
+		safety = definition.CallSafety(arguments)
+	}
+	if safety < maxSafety {
+		return &policyError{fmt.Sprintf("tool %s is %s but the policy only allows %s tools", definition.Name, safety, maxSafety)}
+	}
+	return nil
+}
+
+func (p *PolicyConfig) checkDevice(name string) error {
+	if name == "" {
+		return nil
+	}
+	return checkAllowDeny("device", deviceSerial(name), p.AllowedDevices, p.DeniedDevices)
+}
+
+func (p *PolicyConfig) checkPackage(name string) error {
+	return checkAllowDeny("package", name, p.AllowedPackages, p.DeniedPackages)
+}
+
+// checkCommand refuses device commands matching the default or configured blocked patterns
+func (p *PolicyConfig) checkCommand(command string) error {
+	if p.AllowBlockedCommands {
+		return nil
+	}
+	patterns := append(append([]*regexp.Regexp{}, defaultBlockedPatterns...), p.blockedPatterns...)
+	for _, pattern := range patterns {
+		if pattern.MatchString(command) {
+			return &policyError{fmt.Sprintf("command %q matches blocked pattern %q; set policy.allow_blocked_commands to run it", command, pattern)}
+		}
+	}
+	return nil
+}
+
+// checkCall applies the whole policy to a tool call after its device has been resolved
+func (p *PolicyConfig) checkCall(definition ToolDefinition, call *ToolCall) error {
+	if err := p.checkTool(definition, call.Arguments); err != nil {
+		return err
+	}
+	if err := p.checkDevice(call.Device); err != nil {
+		return err
+	}
+	if name := getStringArgument(call.Arguments, "package"); name != "" {
+		if err := p.checkPackage(name); err != nil {
+			return err
+		}
+	}
+	if definition.Commands != nil {
+		for _, command := range definition.Commands(call.Arguments) {
+			if err := p.checkCommand(command); err != nil {
+				return err
+			}
+			for _, name := range commandPackages(command) {
+				if err := p.checkPackage(name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// packageNamePattern matches Android package names such as com.example.app
+var packageNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z0-9_]+)+$`)
+
+// commandPackages returns the packages a device command line acts on through pm, cmd package, am, cmd activity
+// and monkey, so the package lists also apply to android_shell
+func commandPackages(command string) []string {
+	var packages []string
+	add := func(value string) bool {
+		// Components are written package/class
+		name, _, _ := strings.Cut(value, "/")
+		if !packageNamePattern.MatchString(name) {
+			return false
+		}
+		if !containsString(packages, name) {
+			packages = append(packages, name)
+		}
+		return true
+	}
+	// firstPackage adds the first argument that is a package name, skipping options such as "--user 0"
+	firstPackage := func(args []string) {
+		for _, arg := range args {
+			if !strings.HasPrefix(arg, "-") && add(arg) {
+				return
+			}
+		}
+	}
+
+	unquote := strings.NewReplacer(`"`, "", `'`, "")
+	for _, simple := range shellSeparators.Split(command, -1) {
+		fields := strings.Fields(unquote.Replace(simple))
+		for len(fields) > 0 && (fields[0] == "exec" || strings.Contains(fields[0], "=")) {
+			fields = fields[1:]
+		}
+		if len(fields) < 2 {
+			continue
+		}
+
+		program, args := path.Base(fields[0]), fields[1:]
+		if program == "cmd" && len(args) > 1 {
+			switch args[0] {
+			case "package":
+				program, args = "pm", args[1:]
+			case "activity":
+				program, args = "am", args[1:]
+			}
+		}
+
+		switch program {
+		case "pm":
+			firstPackage(args[1:])
+		case "am":
+			switch args[0] {
+			case "start", "start-activity", "startservice", "start-service", "start-foreground-service", "broadcast", "instrument":
+				// Intents name the package with -n component or -p package, or end with a component
+				for i := 1; i < len(args); i++ {
+					if (args[i] == "-n" || args[i] == "-p") && i+1 < len(args) {
+						add(args[i+1])
+					}
+				}
+				if last := args[len(args)-1]; strings.Contains(last, "/") && !strings.Contains(last, "://") {
+					add(last)
+				}
+			default:
+				// force-stop, kill, set-debug-app and friends take the package as their argument
+				firstPackage(args[1:])
+			}
+		case "monkey":
+			for i := 0; i+1 < len(args); i++ {
+				if args[i] == "-p" {
+					add(args[i+1])
+				}
+			}
+		}
+	}
+	return packages
+}
+
+// checkAllowDeny applies a deny list first, then an allow list when one is configured
+func checkAllowDeny(kind string, value string, allowed []string, denied []string) error {
+	if pattern := matchingPattern(value, denied); pattern != "" {
+		return &policyError{fmt.Sprintf("%s %s is denied by pattern %q", kind, value, pattern)}
+	}
+	if len(allowed) > 0 && matchingPattern(value, allowed) == "" {
+		return &policyError{fmt.Sprintf("%s %s is not in the allowed list (%s)", kind, value, strings.Join(allowed, ", "))}
+	}
+	return nil
+}
+
+func matchingPattern(value string, patterns []string) string {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// toolAnnotations describes a tool's safety class with the MCP annotation hints
+func toolAnnotations(safety ToolSafety) *ToolAnnotations {
+	return &ToolAnnotations{
+		ReadOnlyHint:    safety == SafetyReadOnly,
+		DestructiveHint: safety == SafetyDestructive,
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var shutdownGracePeriod = 5 * time.Second
+
+// trackedProcess is a running child process that shutdown stops
+type trackedProcess struct {
+	cmd         *exec.Cmd
+	description string
+	done        chan struct{}
+	detached    bool
+}
+
+// shutdownHook stops a long-lived device session, saving anything it still holds, before processes are stopped
+type shutdownHook struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+var (
+	processMutex     sync.Mutex
+	trackedProcesses = make(map[*exec.Cmd]*trackedProcess)
+	shutdownHooks    []shutdownHook
+
+	shutdownOnce   sync.Once
+	shutdownStatus int
+)
+
+// trackProcess registers a started process; the returned function must be called once it has been waited for
+func trackProcess(cmd *exec.Cmd, description string) func() {
+	process := &trackedProcess{cmd: cmd, description: description, done: make(chan struct{})}
+	processMutex.Lock()
+	trackedProcesses[cmd] = process
+	processMutex.Unlock()
+
+	return func() {
+		processMutex.Lock()
+		delete(trackedProcesses, cmd)
+		processMutex.Unlock()
+		close(process.done)
+	}
+}
+
+// detachProcess lets a tracked process outlive the server
+func detachProcess(cmd *exec.Cmd) {
+	processMutex.Lock()
+	defer processMutex.Unlock()
+	if process, ok := trackedProcesses[cmd]; ok {
+		process.detached = true
+	}
+}
+
+// onShutdown registers a hook run at the start of shutdown, within the grace period
+func onShutdown(name string, stop func(ctx context.Context) error) {
+	processMutex.Lock()
+	defer processMutex.Unlock()
+	shutdownHooks = append(shutdownHooks, shutdownHook{name: name, stop: stop})
+}
+
+// shutdown stops sessions and child processes and returns the exit status: 0 when everything stopped cleanly,
+// 1 when a hook failed or a process had to be killed. Later calls wait for the first and return the same status.
+func shutdown(reason string) int {
+	shutdownOnce.Do(func() {
+		shutdownStatus = stopEverything(reason)
+	})
+	return shutdownStatus
+}
+
+func stopEverything(reason string) int {
+	grace := currentConfig().shutdownTimeout()
+	logMessage("info", "server", "Shutting down (%s)", reason)
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	status := 0
+	processMutex.Lock()
+	hooks := append([]shutdownHook(nil), shutdownHooks...)
+	processMutex.Unlock()
+
+	var wg sync.WaitGroup
+	var statusMutex sync.Mutex
+	for _, hook := range hooks {
+		wg.Add(1)
+		go func(hook shutdownHook) {
+			defer wg.Done()
+			if err := hook.stop(ctx); err != nil {
+				logMessage("error", "server", "Failed to stop %s: %v", hook.name, err)
+				statusMutex.Lock()
+				status = 1
+				statusMutex.Unlock()
+			}
+		}(hook)
+	}
+
+	// A hook that ignores ctx must not keep the children from being killed
+	hooksDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(hooksDone)
+	}()
+	select {
+	case <-hooksDone:
+	case <-ctx.Done():
+		logMessage("warning", "server", "Shutdown hooks did not finish within the %s grace period", grace)
+		statusMutex.Lock()
+		status = 1
+		statusMutex.Unlock()
+	}
+
+	// Ask the remaining children to exit, then kill whatever is still running when the grace period ends
+	processMutex.Lock()
+	var processes []*trackedProcess
+	for _, process := range trackedProcesses {
+		if !process.detached {
+			processes = append(processes, process)
+		}
+	}
+	processMutex.Unlock()
+
+	for _, process := range processes {
+		if err := process.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			// Windows cannot deliver SIGTERM
+			process.cmd.Process.Kill()
+		}
+	}
+
+	for _, process := range processes {
+		select {
+		case <-process.done:
+		case <-ctx.Done():
+			logMessage("warning", "server", "Killing %s after the %s grace period", process.description, grace)
+			process.cmd.Process.Kill()
+			statusMutex.Lock()
+			status = 1
+			statusMutex.Unlock()
+		}
+	}
+
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	logMessage("info", "server", "Shutdown complete with status %d", status)
+	return status
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStopEverything(t *testing.T) {
+	originalHooks := shutdownHooks
+	shutdownHooks = nil
+	originalSendNotification := sendNotification
+	sendNotification = func(method string, params interface{}) {}
+	defer func() {
+		shutdownHooks = originalHooks
+		sendNotification = originalSendNotification
+	}()
+
+	startTracked := func(command string) <-chan error {
+		finished := make(chan error, 1)
+		go func() {
+			_, err := runWithTimeout(helperCommand(command), time.Minute)
+			finished <- err
+		}()
+
+		// Wait until the process is running and tracked
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			processMutex.Lock()
+			count := len(trackedProcesses)
+			processMutex.Unlock()
+			if count > 0 {
+				break
+			}
+		}
+		return finished
+	}
+
+	t.Run("Clean", func(t *testing.T) {
+		var hookRan bool
+		onShutdown("test session", func(ctx context.Context) error {
+			hookRan = true
+			return nil
+		})
+		defer func() { shutdownHooks = nil }()
+
+		finished := startTracked("sleep")
+		if status := stopEverything("test"); status != 0 {
+			t.Errorf("expected status 0, got %d", status)
+		}
+		if !hookRan {
+			t.Error("expected the shutdown hook to run")
+		}
+		select {
+		case err := <-finished:
+			if err == nil {
+				t.Error("expected the process to be terminated")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the tracked process to stop")
+		}
+	})
+
+	t.Run("FailingHook", func(t *testing.T) {
+		onShutdown("failing session", func(ctx context.Context) error {
+			return errors.New("artifact pull failed")
+		})
+		defer func() { shutdownHooks = nil }()
+
+		if status := stopEverything("test"); status != 1 {
+			t.Errorf("expected status 1, got %d", status)
+		}
+	})
+
+	t.Run("HungHook", func(t *testing.T) {
+		useConfig(t, &Config{Timeouts: TimeoutConfig{ShutdownSeconds: 0.5}})
+		release := make(chan struct{})
+		defer close(release)
+		onShutdown("hung session", func(ctx context.Context) error {
+			// Ignores ctx, like a hook stuck on a device that stopped answering
+			<-release
+			return nil
+		})
+		defer func() { shutdownHooks = nil }()
+
+		finished := startTracked("ignore-sigterm")
+		time.Sleep(500 * time.Millisecond)
+
+		started := time.Now()
+		if status := stopEverything("test"); status != 1 {
+			t.Errorf("expected status 1, got %d", status)
+		}
+		if elapsed := time.Since(started); elapsed > 5*time.Second {
+			t.Errorf("expected shutdown to end after the grace period, took %s", elapsed)
+		}
+		select {
+		case <-finished:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the process to be killed despite the hung hook")
+		}
+	})
+
+	t.Run("KilledAfterGracePeriod", func(t *testing.T) {
+		useConfig(t, &Config{Timeouts: TimeoutConfig{ShutdownSeconds: 0.5}})
+
+		finished := startTracked("ignore-sigterm")
+		// Give the helper time to start ignoring SIGTERM
+		time.Sleep(500 * time.Millisecond)
+
+		started := time.Now()
+		if status := stopEverything("test"); status != 1 {
+			t.Errorf("expected status 1, got %d", status)
+		}
+		if elapsed := time.Since(started); elapsed < 500*time.Millisecond {
+			t.Errorf("expected the grace period to be honored, stopped after %s", elapsed)
+		}
+		select {
+		case <-finished:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the process to be killed")
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// promptDefinition is a guided workflow that expands into instructions using the server's tools
+type promptDefinition struct {
+	name        string
+	description string
+	arguments   []PromptArgument
+	render      func(arguments map[string]string) string
+}
+
+var devicePromptArgument = PromptArgument{
+	Name:        "device",
+	Description: "Device selector (serial, AVD name, model or filter); the first ready device if empty",
+}
+
+// prompts lists the workflows in the order prompts/list reports them
+var prompts = []promptDefinition{
+	{
+		name:        "reproduce_bug",
+		description: "Reproduce a reported bug on a device step by step, capturing evidence along the way",
+		arguments: []PromptArgument{
+			{Name: "steps", Description: "Steps to reproduce, as written in the bug report", Required: true},
+			{Name: "expected", Description: "What should happen instead"},
+			devicePromptArgument,
+		},
+		render: func(arguments map[string]string) string {
+			text := fmt.Sprintf(`Reproduce this bug on %s.
+
+Steps to reproduce:
+%s
+`, promptDevice(arguments), arguments["steps"])
+			if expected := arguments["expected"]; expected != "" {
+				text += fmt.Sprintf("\nExpected behavior:\n%s\n", expected)
+			}
+			return text + `
+1. Call get_android_devices and confirm the device is ready; note its model, Android version and SDK level.
+2. Before each step, read the android://{serial}/ui resource or call get_android_screen to see the current screen.
+3. Perform each step, using android_shell with "input tap", "input text" or "am start" where needed.
+4. After each step, capture a screenshot and compare the screen with what the step should produce.
+5. When the bug appears, collect "logcat -d -t 300" with android_shell.
+6. Report whether the bug reproduced, the exact steps that triggered it, the screenshots and the relevant log lines.`
+		},
+	},
+	{
+		name:        "smoke_test_apk",
+		description: "Launch an app, walk through its main screens and report crashes, ANRs and visual problems",
+		arguments: []PromptArgument{
+			{Name: "package", Description: "Package name of the app, e.g. com.example.app", Required: true},
+			{Name: "apk_path", Description: "Host path of the APK, if it still has to be installed"},
+			devicePromptArgument,
+		},
+		render: func(arguments map[string]string) string {
+			pkg := arguments["package"]
+			text := fmt.Sprintf("Smoke-test the app %s on %s.\n\n", pkg, promptDevice(arguments))
+			if apkPath := arguments["apk_path"]; apkPath != "" {
+				text += fmt.Sprintf("This server cannot copy files to the device, so ask the user to run \"adb install -r %s\" first if the app is missing.\n\n", apkPath)
+			}
+			return text + fmt.Sprintf(`1. Check the app is installed by running "pm path %[1]s" with android_shell.
+2. Clear old logs with "logcat -c", then start the app with "monkey -p %[1]s -c android.intent.category.LAUNCHER 1".
+3. Capture a screenshot with get_android_screen and describe the first screen.
+4. Visit the main screens reachable from the first screen, taking a screenshot of each.
+5. Run "logcat -d -b crash" and "logcat -d *:E" with android_shell and look for crashes or ANRs from %[1]s.
+6. Report pass or fail, every crash or error with its stack trace, and any layout or rendering problems you saw.`, pkg)
+		},
+	},
+	{
+		name:        "check_accessibility",
+		description: "Review the current screen for accessibility problems",
+		arguments:   []PromptArgument{devicePromptArgument},
+		render: func(arguments map[string]string) string {
+			return fmt.Sprintf(`Check the accessibility of the current screen on %s.
+
+1. Read the android://{serial}/ui resource to get the view hierarchy, and call get_android_screen for a screenshot.
+2. List clickable or focusable nodes that have neither text nor content-desc, since screen readers cannot announce them.
+3. Flag touch targets smaller than 48x48dp, using the bounds in the hierarchy and the density from get_android_devices.
+4. Point out text with low contrast against its background and text that looks too small in the screenshot.
+5. Note images that convey information but have no content description.
+6. Report each problem with the node's resource-id, class and bounds, and suggest a fix.`, promptDevice(arguments))
+		},
+	},
+	{
+		name:        "investigate_crash",
+		description: "Find and explain the most recent crash of an app",
+		arguments: []PromptArgument{
+			{Name: "package", Description: "Package name of the crashing app", Required: true},
+			devicePromptArgument,
+		},
+		render: func(arguments map[string]string) string {
+			return fmt.Sprintf(`Investigate the latest crash of %[1]s on %[2]s.
+
+1. Run "logcat -d -b crash" with android_shell and find the most recent FATAL EXCEPTION or native crash for %[1]s.
+2. If there is none, run "logcat -d -t 2000" and search for "ANR in %[1]s" or "Process: %[1]s".
+3. Run "dumpsys package %[1]s | grep versionName" to record the app version.
+4. Use get_android_devices for the device model, Android version and SDK level.
+5. Explain the likely cause from the stack trace, pointing at the first frame in the app's own code.
+6. Suggest how to reproduce the crash and how to fix it.`, arguments["package"], promptDevice(arguments))
+		},
+	},
+}
+
+// promptDevice describes the device argument for prompt text
+func promptDevice(arguments map[string]string) string {
+	if device := arguments["device"]; device != "" {
+		return fmt.Sprintf("the device matching %q", device)
+	}
+	return "the first ready device"
+}
+
+func handlePromptsList(request JSONRPCRequest) {
+	list := make([]Prompt, 0, len(prompts))
+	for _, prompt := range prompts {
+		list = append(list, Prompt{
+			Name:        prompt.name,
+			Description: prompt.description,
+			Arguments:   prompt.arguments,
+		})
+	}
+
+	sendResponse(JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  PromptsListResult{Prompts: list},
+	})
+}
+
+func handlePromptsGet(request JSONRPCRequest) {
+	var params PromptsGetParams
+	if request.Params != nil {
+		paramsBytes, _ := json.Marshal(request.Params)
+		if err := json.Unmarshal(paramsBytes, &params); err != nil {
+			sendError(request.ID, -32602, "Invalid params", nil)
+			return
+		}
+	}
+
+	for _, prompt := range prompts {
+		if prompt.name != params.Name {
+			continue
+		}
+
+		arguments := make(map[string]string)
+		for _, argument := range prompt.arguments {
+			value := strings.TrimSpace(params.Arguments[argument.Name])
+			if argument.Required && value == "" {
+				sendError(request.ID, -32602, "Invalid params", map[string]interface{}{
+					"error": argument.Name + " is required",
+				})
+				return
+			}
+			arguments[argument.Name] = value
+		}
+
+		sendResponse(JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Result: PromptsGetResult{
+				Description: prompt.description,
+				Messages: []PromptMessage{
+					{
+						Role:    "user",
+						Content: ContentItem{Type: "text", Text: prompt.render(arguments)},
+					},
+				},
+			},
+		})
+		return
+	}
+
+	sendError(request.ID, -32602, "Unknown prompt: "+params.Name, nil)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPrompts(t *testing.T) {
+	var response JSONRPCResponse
+	originalSendResponse := sendResponse
+	sendResponse = func(resp JSONRPCResponse) { response = resp }
+	defer func() { sendResponse = originalSendResponse }()
+
+	handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "prompts/list"})
+	list, ok := response.Result.(PromptsListResult)
+	if !ok || len(list.Prompts) != 4 {
+		t.Fatalf("expected 4 prompts, got %+v", response)
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 2, Method: "prompts/get", Params: map[string]interface{}{
+			"name":      "investigate_crash",
+			"arguments": map[string]interface{}{"package": "com.example.app", "device": "sdk>=33"},
+		}})
+		result, ok := response.Result.(PromptsGetResult)
+		if !ok || len(result.Messages) != 1 || result.Messages[0].Role != "user" {
+			t.Fatalf("expected one user message, got %+v", response)
+		}
+		text := result.Messages[0].Content.Text
+		if !strings.Contains(text, "latest crash of com.example.app on the device matching \"sdk>=33\"") {
+			t.Errorf("expected arguments in prompt text, got %q", text)
+		}
+	})
+
+	t.Run("MissingArgument", func(t *testing.T) {
+		handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 3, Method: "prompts/get", Params: map[string]interface{}{"name": "smoke_test_apk"}})
+		if response.Error == nil || response.Error.Code != -32602 || response.Error.Data.(map[string]interface{})["error"] != "package is required" {
+			t.Errorf("expected missing argument error, got %+v", response)
+		}
+	})
+
+	t.Run("UnknownPrompt", func(t *testing.T) {
+		handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 4, Method: "prompts/get", Params: map[string]interface{}{"name": "missing"}})
+		if response.Error == nil || response.Error.Message != "Unknown prompt: missing" {
+			t.Errorf("expected unknown prompt error, got %+v", response)
+		}
+	})
+
+	// Prompts must only send the model to tools that exist
+	toolName := regexp.MustCompile(`\b(?:get_)?android_[a-z_]+\b`)
+	for _, prompt := range prompts {
+		text := prompt.render(map[string]string{"package": "com.example.app", "steps": "open the app", "apk_path": "app.apk"})
+		for _, name := range toolName.FindAllString(text, -1) {
+			if _, exists := toolRegistry.Lookup(name); !exists {
+				t.Errorf("prompt %s references unknown tool %s", prompt.name, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DeviceMode describes how a tool's "device" argument is resolved before its handler runs
+type DeviceMode int
+
+const (
+	// DeviceNone means the tool does not operate on a specific device
+	DeviceNone DeviceMode = iota
+	// DeviceOptional falls back to the first available device when no device is given
+	DeviceOptional
+	// DeviceRequired requires the caller to name the device
+	DeviceRequired
+	// DeviceEmulator falls back to the first running emulator and rejects physical devices
+	DeviceEmulator
+)
+
+// ToolCall carries the validated arguments and resolved device for a single tools/call
+type ToolCall struct {
+	Request   JSONRPCRequest
+	Arguments map[string]interface{}
+	Device    string
+
+	ctx           context.Context
+	progressToken interface{}
+	lastProgress  float64
+}
+
+// Context carries the call's audit record to the commands the handler runs
+func (c *ToolCall) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// ReportProgress sends notifications/progress if the client passed a progress token; progress must keep increasing,
+// so reports that do not advance it are dropped
+func (c *ToolCall) ReportProgress(progress float64, total float64, message string) {
+	if c.progressToken == nil || progress <= c.lastProgress {
+		return
+	}
+	c.lastProgress = progress
+	sendNotification("notifications/progress", ProgressParams{
+		ProgressToken: c.progressToken,
+		Progress:      progress,
+		Total:         total,
+		Message:       message,
+	})
+}
+
+// ToolDefinition declares everything the server needs to list and dispatch a tool
+type ToolDefinition struct {
+	Name         string
+	Description  string
+	InputSchema  map[string]interface{}
+	OutputSchema map[string]interface{}
+	Device       DeviceMode
+	Safety       ToolSafety
+	// CallSafety optionally classifies a single call from its arguments, for tools whose safety depends on the action;
+	// Safety remains the most severe class and is what the annotations report
+	CallSafety func(arguments map[string]interface{}) ToolSafety
+	// Commands optionally returns the device commands a call would run, for the blocked command check
+	Commands func(arguments map[string]interface{}) []string
+	Handler  func(call *ToolCall) (ToolsCallResult, error)
+}
+
+// ToolRegistry holds tool definitions in registration order
+type ToolRegistry struct {
+	tools []ToolDefinition
+	index map[string]int
+}
+
+// invalidParamsError marks handler errors caused by bad arguments rather than device failures
+type invalidParamsError struct {
+	err error
+}
+
+func (e *invalidParamsError) Error() string {
+	return e.err.Error()
+}
+
+func (e *invalidParamsError) Unwrap() error {
+	return e.err
+}
+
+func invalidParams(format string, args ...interface{}) error {
+	return &invalidParamsError{err: fmt.Errorf(format, args...)}
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{index: make(map[string]int)}
+}
+
+// Register adds a tool, injecting the "device" argument into its input schema according to its DeviceMode
+func (r *ToolRegistry) Register(definition ToolDefinition) {
+	if _, exists := r.index[definition.Name]; exists {
+		panic("duplicate tool registration: " + definition.Name)
+	}
+
+	if definition.InputSchema == nil {
+		definition.InputSchema = map[string]interface{}{}
+	}
+	if _, ok := definition.InputSchema["type"]; !ok {
+		definition.InputSchema["type"] = "object"
+	}
+	if _, ok := definition.InputSchema["properties"]; !ok {
+		definition.InputSchema["properties"] = map[string]interface{}{}
+	}
+
+	properties := definition.InputSchema["properties"].(map[string]interface{})
+	switch definition.Device {
+	case DeviceOptional:
+		properties["device"] = map[string]interface{}{
+			"type":        "string",
+			"description": "Device selector: serial (e.g., 'emulator-5554'), transport id, AVD name, model substring, or filter such as 'sdk>=33,emulator=true'. If not provided, uses the first ready device.",
+		}
+	case DeviceRequired:
+		properties["device"] = map[string]interface{}{
+			"type":        "string",
+			"description": "Device selector: serial (e.g., 'emulator-5554'), transport id, AVD name, model substring, or filter such as 'sdk>=33,emulator=true'",
+		}
+		required := schemaList(definition.InputSchema["required"])
+		definition.InputSchema["required"] = append(required, "device")
+	case DeviceEmulator:
+		properties["device"] = map[string]interface{}{
+			"type":        "string",
+			"description": "Emulator selector: serial (e.g., 'emulator-5554'), transport id, AVD name, or filter such as 'sdk>=33'. If not provided, uses the first running emulator.",
+		}
+	}
+
+	r.index[definition.Name] = len(r.tools)
+	r.tools = append(r.tools, definition)
+}
+
+func (r *ToolRegistry) Lookup(name string) (ToolDefinition, bool) {
+	i, exists := r.index[name]
+	if !exists {
+		return ToolDefinition{}, false
+	}
+	return r.tools[i], true
+}
+
+// Tools returns the enabled MCP tool descriptions in registration order, with output schemas when the client supports them
+func (r *ToolRegistry) Tools() []Tool {
+	tools := make([]Tool, 0, len(r.tools))
+	config := currentConfig()
+	for _, definition := range r.tools {
+		if !config.toolEnabled(definition.Name) {
+			continue
+		}
+
+		tool := Tool{
+			Name:        definition.Name,
+			Description: definition.Description,
+			InputSchema: definition.InputSchema,
+		}
+		if structuredOutputSupported() {
+			tool.OutputSchema = definition.OutputSchema
+		}
+		if toolAnnotationsSupported() {
+			tool.Annotations = toolAnnotations(definition.Safety)
+		}
+		tools = append(tools, tool)
+	}
+	return tools
+}
+
+// Call validates the arguments, resolves the device and runs the tool handler, sending the response
+func (r *ToolRegistry) Call(request JSONRPCRequest, params ToolsCallParams) {
+	definition, exists := r.Lookup(params.Name)
+	if !exists {
+		sendError(request.ID, -32602, "Unknown tool: "+params.Name, nil)
+		return
+	}
+	if !currentConfig().toolEnabled(params.Name) {
+		sendError(request.ID, -32602, "Tool disabled by configuration: "+params.Name, nil)
+		return
+	}
+
+	arguments := params.Arguments
+	if arguments == nil {
+		arguments = map[string]interface{}{}
+	}
+
+	if err := validateSchema(definition.InputSchema, arguments, ""); err != nil {
+		sendError(request.ID, -32602, "Invalid params", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	audit := beginAudit(params.Name, arguments)
+	defer audit.end()
+	ctx := withAudit(context.Background(), audit)
+
+	deviceName, err := resolveDevice(ctx, definition.Device, arguments)
+	if err != nil {
+		audit.fail("error", err)
+		sendResponse(JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Result:  toolErrorResult(err),
+		})
+		return
+	}
+
+	call := &ToolCall{
+		Request:   request,
+		Arguments: arguments,
+		Device:    deviceName,
+		ctx:       ctx,
+	}
+	if params.Meta != nil {
+		call.progressToken = params.Meta.ProgressToken
+	}
+	audit.setDevice(deviceName)
+	if err := currentConfig().Policy.checkCall(definition, call); err != nil {
+		audit.fail("blocked", err)
+		sendResponse(JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Result:  toolErrorResult(err),
+		})
+		return
+	}
+
+	result, err := definition.Handler(call)
+	if err != nil {
+		var paramsErr *invalidParamsError
+		if errors.As(err, &paramsErr) {
+			audit.fail("invalid_params", err)
+			sendError(request.ID, -32602, "Invalid params", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+		// Execution failures are reported to the model as tool results, not protocol errors
+		var blockedErr *policyError
+		if errors.As(err, &blockedErr) {
+			audit.fail("blocked", err)
+		} else {
+			audit.fail("error", err)
+		}
+		result = toolErrorResult(err)
+	}
+
+	// Older clients only understand the text content, which handlers always provide
+	if !structuredOutputSupported() {
+		result.StructuredContent = nil
+	}
+
+	response := JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  result,
+	}
+	sendResponse(response)
+}
+
+// resolveDevice applies a tool's DeviceMode to the "device" selector argument
+func resolveDevice(ctx context.Context, mode DeviceMode, arguments map[string]interface{}) (string, error) {
+	selector := getStringArgument(arguments, "device")
+
+	switch mode {
+	case DeviceNone:
+		return "", nil
+	case DeviceOptional, DeviceRequired:
+		return resolveDeviceSelector(ctx, selector, false)
+	case DeviceEmulator:
+		return resolveDeviceSelector(ctx, selector, true)
+	}
+
+	return "", fmt.Errorf("unknown device mode %d", mode)
+}
+
+func textResult(text string) ToolsCallResult {
+	return ToolsCallResult{
+		Content: []ContentItem{
+			{
+				Type: "text",
+				Text: text,
+			},
+		},
+		IsError: false,
+	}
+}
+
+// toolErrorResult describes a failed tool execution, adding a remediation hint for well-known adb failures
+func toolErrorResult(err error) ToolsCallResult {
+	text := err.Error()
+	if hint := adbErrorHint(text); hint != "" {
+		text += "\nHint: " + hint
+	}
+
+	return ToolsCallResult{
+		Content: []ContentItem{
+			{
+				Type: "text",
+				Text: text,
+			},
+		},
+		IsError: true,
+	}
+}
+
+// adbErrorHints maps patterns of lowercased adb and emulator error output to remediation advice, checked in order
+var adbErrorHints = []struct {
+	pattern string
+	hint    string
+}{
+	{"blocked by safety policy", "the server's safety policy refuses this call: change the policy section of the config file if it should be allowed"},
+	{"unauthorized", "device unauthorized: accept the RSA key prompt (\"Allow USB debugging?\") on the device screen"},
+	{"device offline", "device offline: reconnect the USB cable or run `adb reconnect offline`"},
+	{"adb command not found", "install the Android SDK platform-tools and add adb to your PATH"},
+	{"emulator command not found", "install the Android Emulator from the SDK manager and set ANDROID_HOME"},
+	{"wrong password", "the pairing code is wrong or expired: tap \"Pair device with pairing code\" again and use the new code and port"},
+	{"failed to connect to", "check the device is on the same network with Wireless debugging enabled, or run android_adb_tcpip over USB first"},
+	{"cannot connect to daemon", "the adb server is not running: run `adb start-server`"},
+	{"insufficient permissions", "adb lacks USB permissions: add a udev rule for the device or run `adb kill-server` and retry"},
+	{"more than one device", "several devices are connected: pass the device argument"},
+	{"no android emulators found", "start an emulator with android_start_emulator or pass the device argument"},
+	{"no android devices found", "connect a device with USB debugging enabled or start an emulator"},
+	{"matches several devices", "pass a serial or a more specific selector from the candidates"},
+	{"not ready", "wait for the device to come online or accept the USB debugging prompt"},
+	{`device '[^']*' not found`, "check the serial with get_android_devices"},
+	{`^emulator \S+ not found`, "check the serial with get_android_devices"},
+	{"no device matches selector", "check the selector against get_android_devices"},
+	{"emulator console authentication failed", "check ~/.emulator_console_auth_token matches the running emulator"},
+	{"connection refused", "the emulator console is not reachable: make sure the emulator is running"},
+}
+
+func adbErrorHint(message string) string {
+	lower := strings.ToLower(message)
+	for _, entry := range adbErrorHints {
+		if regexp.MustCompile(entry.pattern).MatchString(lower) {
+			return entry.hint
+		}
+	}
+	return ""
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"action": map[string]interface{}{
+				"type": "string",
+				"enum": []string{"save", "load"},
+			},
+			"level": map[string]interface{}{
+				"type":    "integer",
+				"minimum": 0,
+				"maximum": 100,
+			},
+			"tags": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string"},
+			},
+		},
+		"required": []string{"action"},
+	}
+
+	tests := []struct {
+		name      string
+		arguments map[string]interface{}
+		wantErr   string
+	}{
+		{"Valid", map[string]interface{}{"action": "save", "level": float64(50), "tags": []interface{}{"a"}}, ""},
+		{"MissingRequired", map[string]interface{}{}, "action is required"},
+		{"NotInEnum", map[string]interface{}{"action": "drop"}, "action must be one of: save, load"},
+		{"WrongType", map[string]interface{}{"action": "save", "level": "high"}, "level must be of type integer"},
+		{"NotInteger", map[string]interface{}{"action": "save", "level": 1.5}, "level must be of type integer"},
+		{"AboveMaximum", map[string]interface{}{"action": "save", "level": float64(101)}, "level must be <= 100"},
+		{"BadItem", map[string]interface{}{"action": "save", "tags": []interface{}{"a", true}}, "tags[1] must be of type string"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateSchema(schema, test.arguments, "")
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("expected error %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestToolRegistry(t *testing.T) {
+	registry := NewToolRegistry()
+	var received *ToolCall
+	registry.Register(ToolDefinition{
+		Name:        "test_tool",
+		Description: "Test tool",
+		InputSchema: map[string]interface{}{
+			"properties": map[string]interface{}{
+				"count": map[string]interface{}{"type": "integer"},
+			},
+		},
+		Device: DeviceRequired,
+		Handler: func(call *ToolCall) (ToolsCallResult, error) {
+			received = call
+			if count, _ := getNumberArgument(call.Arguments, "count"); count < 0 {
+				return ToolsCallResult{}, fmt.Errorf("failed to capture screenshot: exit status 1, stderr: error: device unauthorized.")
+			}
+			if _, ok := getNumberArgument(call.Arguments, "count"); !ok {
+				return ToolsCallResult{}, invalidParams("count is required when device is %s", call.Device)
+			}
+			return textResult("ok"), nil
+		},
+	})
+
+	tools := registry.Tools()
+	if len(tools) != 1 {
+		t.Fatalf("expected 1 tool, got %d", len(tools))
+	}
+	properties := tools[0].InputSchema["properties"].(map[string]interface{})
+	if _, ok := properties["device"]; !ok {
+		t.Error("expected device property to be injected into the input schema")
+	}
+
+	var response JSONRPCResponse
+	originalSendResponse := sendResponse
+	sendResponse = func(resp JSONRPCResponse) { response = resp }
+	originalExecCommand := execCommand
+	execCommand = helperCommand
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) { return file, nil }
+	defer func() {
+		sendResponse = originalSendResponse
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+	}()
+
+	t.Run("MissingDevice", func(t *testing.T) {
+		registry.Call(JSONRPCRequest{ID: 1}, ToolsCallParams{Name: "test_tool"})
+		if response.Error == nil || response.Error.Code != -32602 {
+			t.Fatalf("expected invalid params error, got %+v", response)
+		}
+		data := response.Error.Data.(map[string]interface{})
+		if data["error"] != "device is required" {
+			t.Errorf("unexpected error data: %v", data)
+		}
+	})
+
+	t.Run("HandlerInvalidParams", func(t *testing.T) {
+		registry.Call(JSONRPCRequest{ID: 2}, ToolsCallParams{
+			Name:      "test_tool",
+			Arguments: map[string]interface{}{"device": "emulator-5554"},
+		})
+		if response.Error == nil || response.Error.Code != -32602 {
+			t.Fatalf("expected invalid params error, got %+v", response)
+		}
+		if received == nil || received.Device != "emulator-5554" {
+			t.Errorf("expected device to be resolved, got %+v", received)
+		}
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		registry.Call(JSONRPCRequest{ID: 3}, ToolsCallParams{
+			Name:      "test_tool",
+			Arguments: map[string]interface{}{"device": "emulator-5554", "count": float64(2)},
+		})
+		result, ok := response.Result.(ToolsCallResult)
+		if !ok || result.Content[0].Text != "ok" {
+			t.Errorf("unexpected response: %+v", response)
+		}
+	})
+
+	t.Run("HandlerFailure", func(t *testing.T) {
+		registry.Call(JSONRPCRequest{ID: 4}, ToolsCallParams{
+			Name:      "test_tool",
+			Arguments: map[string]interface{}{"device": "emulator-5554", "count": float64(-1)},
+		})
+		if response.Error != nil {
+			t.Fatalf("expected a tool result, got protocol error %+v", response.Error)
+		}
+		result, ok := response.Result.(ToolsCallResult)
+		if !ok || !result.IsError {
+			t.Fatalf("expected isError result, got %+v", response.Result)
+		}
+		text := result.Content[0].Text
+		if !strings.Contains(text, "stderr: error: device unauthorized.") || !strings.Contains(text, "Hint: device unauthorized: accept the RSA key prompt") {
+			t.Errorf("unexpected error text: %q", text)
+		}
+	})
+
+	t.Run("UnknownTool", func(t *testing.T) {
+		registry.Call(JSONRPCRequest{ID: 4}, ToolsCallParams{Name: "missing"})
+		if response.Error == nil || !strings.Contains(response.Error.Message, "Unknown tool") {
+			t.Errorf("expected unknown tool error, got %+v", response)
+		}
+	})
+}
+
+func TestADBErrorHint(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"failed to run command: exit status 1, stderr: adb: device 'R58M123ABC' not found", "check the serial with get_android_devices"},
+		{"emulator emulator-5560 not found", "check the serial with get_android_devices"},
+		{"failed to remove forward rules on device emulator-5554: exit status 1, stderr: adb: error: listener 'tcp:1234' not found", ""},
+		{"/system/bin/sh: foo: inaccessible or not found", ""},
+		{"adb command not found: exec: \"adb\": executable file not found in $PATH", "install the Android SDK platform-tools and add adb to your PATH"},
+	}
+
+	for _, test := range tests {
+		if hint := adbErrorHint(test.message); hint != test.want {
+			t.Errorf("%q: expected hint %q, got %q", test.message, test.want, hint)
+		}
+	}
+}
+
+func TestResolveDeviceSelector(t *testing.T) {
+	originalExecCommand := execCommand
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		cmd := helperCommand(command, args...)
+		cmd.Env = append(cmd.Env, "HELPER_DEVICES="+strings.Join([]string{
+			"R58M123ABC\tunauthorized usb:1-1 transport_id:3",
+			"emulator-5554\tdevice product:sdk_gphone_x86 transport_id:1",
+			"emulator-5556\toffline transport_id:2",
+		}, "\n"))
+		return cmd
+	}
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) { return file, nil }
+	defer func() {
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+	}()
+
+	tests := []struct {
+		name         string
+		selector     string
+		emulatorOnly bool
+		want         string
+		wantErr      string
+	}{
+		{"DefaultSkipsUnauthorized", "", false, "emulator-5554", ""},
+		{"Serial", "R58M123ABC", false, "R58M123ABC", ""},
+		{"TransportID", "1", false, "emulator-5554", ""},
+		{"AVDName", "Pixel_2_API_30", false, "emulator-5554", ""},
+		{"ModelSubstring", "gphone", false, "emulator-5554", ""},
+		{"Filter", "sdk>=30,emulator=true", false, "emulator-5554", ""},
+		{"FilterNoMatch", "sdk>=33", false, "", `no device matches selector "sdk>=33"; candidates: R58M123ABC (unauthorized)`},
+		{"UnknownFilterKey", "sdk>=30,color=red", false, "", `unknown device filter key "color"`},
+		{"NotReady", "status=offline", false, "", "only matches devices that are not ready; candidates: emulator-5556 (offline)"},
+		{"EmulatorOnlyRejectsPhone", "R58M123ABC", true, "", `no device matches selector "R58M123ABC"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := resolveDeviceSelector(context.Background(), test.selector, test.emulatorOnly)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMatchDeviceSelectorAmbiguous(t *testing.T) {
+	devices := []Device{
+		{Device: "A", RunStatus: "device", Model: "Pixel 7", SDKLevel: "34"},
+		{Device: "B", RunStatus: "device", Model: "Pixel 6", SDKLevel: "33"},
+	}
+
+	matches, err := matchDeviceSelector("pixel", devices)
+	if err != nil || len(matches) != 2 {
+		t.Fatalf("expected both devices to match, got %v, %v", matches, err)
+	}
+
+	matches, err = matchDeviceSelector("sdk<34", devices)
+	if err != nil || len(matches) != 1 || matches[0].Device != "B" {
+		t.Errorf("expected only B to match, got %v, %v", matches, err)
+	}
+}
+
+func TestCommandPackages(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"pm uninstall -k --user 0 com.example.app", []string{"com.example.app"}},
+		{"/system/bin/pm grant com.example.app android.permission.CAMERA", []string{"com.example.app"}},
+		{"cmd package clear 'com.example.app'", []string{"com.example.app"}},
+		{"am start -a android.intent.action.VIEW -d https://example.com -n com.android.chrome/.Main", []string{"com.android.chrome"}},
+		{"am start com.example.app/.MainActivity", []string{"com.example.app"}},
+		{"am broadcast -a com.example.PING -p com.example.app", []string{"com.example.app"}},
+		{"cmd activity force-stop com.example.app && monkey -p com.android.settings -v 100", []string{"com.example.app", "com.android.settings"}},
+		{"am instrument -w com.example.app.test/androidx.test.runner.AndroidJUnitRunner", []string{"com.example.app.test"}},
+		{"pm list packages", nil},
+		{"dumpsys package com.example.app", nil},
+	}
+
+	for _, test := range tests {
+		if packages := commandPackages(test.command); !reflect.DeepEqual(packages, test.want) {
+			t.Errorf("%q: expected %q, got %q", test.command, test.want, packages)
+		}
+	}
+}
+
+func TestSafetyPolicy(t *testing.T) {
+	originalProtocolVersion := protocolVersion
+	protocolVersion = "2025-03-26"
+	defer func() { protocolVersion = originalProtocolVersion }()
+
+	for _, tool := range toolRegistry.Tools() {
+		if tool.Annotations == nil {
+			t.Fatalf("expected annotations for %s", tool.Name)
+		}
+		switch tool.Name {
+		case "get_android_devices":
+			if !tool.Annotations.ReadOnlyHint || tool.Annotations.DestructiveHint {
+				t.Errorf("expected %s to be read-only, got %+v", tool.Name, tool.Annotations)
+			}
+		case "android_kill_emulator":
+			if tool.Annotations.ReadOnlyHint || !tool.Annotations.DestructiveHint {
+				t.Errorf("expected %s to be destructive, got %+v", tool.Name, tool.Annotations)
+			}
+		}
+	}
+
+	var response JSONRPCResponse
+	originalSendResponse := sendResponse
+	sendResponse = func(resp JSONRPCResponse) { response = resp }
+	originalExecCommand := execCommand
+	execCommand = helperCommand
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) { return file, nil }
+	defer func() {
+		sendResponse = originalSendResponse
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+	}()
+
+	tests := []struct {
+		name      string
+		policy    PolicyConfig
+		tool      string
+		arguments map[string]interface{}
+		wantErr   string
+	}{
+		{"MaxToolSafety", PolicyConfig{MaxToolSafety: "interactive"}, "android_kill_emulator", map[string]interface{}{"device": "emulator-5554"}, "tool android_kill_emulator is destructive but the policy only allows interactive tools"},
+		{"DeniedDevice", PolicyConfig{DeniedDevices: []string{"emulator-*"}}, "android_kill_emulator", map[string]interface{}{"device": "emulator-5554"}, `device emulator-5554 is denied by pattern "emulator-*"`},
+		{"NotAllowedDevice", PolicyConfig{AllowedDevices: []string{"R58M*"}}, "android_kill_emulator", map[string]interface{}{"device": "emulator-5554"}, "device emulator-5554 is not in the allowed list (R58M*)"},
+		{"BlockedShellCommand", PolicyConfig{}, "android_shell", map[string]interface{}{"device": "emulator-5554", "command": "sync && reboot"}, `command "sync && reboot" matches blocked pattern "\\breboot\\b"`},
+		{"DeniedShellPackage", PolicyConfig{DeniedPackages: []string{"com.android.*"}}, "android_shell", map[string]interface{}{"device": "emulator-5554", "command": "pm uninstall --user 0 com.android.chrome"}, `package com.android.chrome is denied by pattern "com.android.*"`},
+		{"NotAllowedShellPackage", PolicyConfig{AllowedPackages: []string{"com.example.*"}}, "android_shell", map[string]interface{}{"device": "emulator-5554", "command": "am start -a android.intent.action.MAIN -n com.android.settings/.Settings"}, "package com.android.settings is not in the allowed list (com.example.*)"},
+		{"BlockedCommand", PolicyConfig{}, "android_start_emulator", map[string]interface{}{"avd_name": "Pixel_2_API_30", "wipe_data": true}, `command "emulator -avd Pixel_2_API_30 -wipe-data" matches blocked pattern`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, &Config{Policy: test.policy})
+			toolRegistry.Call(JSONRPCRequest{ID: 1}, ToolsCallParams{Name: test.tool, Arguments: test.arguments})
+
+			result, ok := response.Result.(ToolsCallResult)
+			if !ok || !result.IsError {
+				t.Fatalf("expected isError result, got %+v", response)
+			}
+			text := result.Content[0].Text
+			if !strings.HasPrefix(text, "blocked by safety policy: "+test.wantErr) || !strings.Contains(text, "Hint: the server's safety policy") {
+				t.Errorf("unexpected error text: %q", text)
+			}
+		})
+	}
+
+	snapshot, _ := toolRegistry.Lookup("android_emulator_snapshot")
+	readOnly := PolicyConfig{MaxToolSafety: "read_only"}
+	if err := readOnly.checkTool(snapshot, map[string]interface{}{"action": "list"}); err != nil {
+		t.Errorf("expected snapshot list to be read-only, got %v", err)
+	}
+	if err := readOnly.checkTool(snapshot, map[string]interface{}{"action": "load", "name": "clean_state"}); err == nil {
+		t.Error("expected snapshot load to be refused by a read-only policy")
+	}
+	forward, _ := toolRegistry.Lookup("android_adb_forward")
+	if err := readOnly.checkTool(forward, map[string]interface{}{"action": "list"}); err != nil {
+		t.Errorf("expected forward list to be read-only, got %v", err)
+	}
+	if err := readOnly.checkTool(forward, map[string]interface{}{"action": "create", "local": "tcp:0", "remote": "tcp:8080"}); err == nil {
+		t.Error("expected forward create to be refused by a read-only policy")
+	}
+
+	policy := PolicyConfig{DeniedPackages: []string{"com.android.*"}, AllowedPackages: []string{"com.example.*", "com.android.settings"}}
+	if err := policy.checkPackage("com.example.app"); err != nil {
+		t.Errorf("expected com.example.app to be allowed, got %v", err)
+	}
+	if err := policy.checkPackage("com.android.settings"); err == nil {
+		t.Error("expected the deny list to take precedence over the allow list")
+	}
+	if err := policy.checkCommand("rm -f -r /sdcard/Download"); err == nil {
+		t.Error("expected recursive rm to be blocked")
+	}
+	if err := policy.checkCommand("rm /sdcard/Download/log.txt"); err != nil {
+		t.Errorf("expected single file rm to be allowed, got %v", err)
+	}
+	policy.BlockedCommands = []string{`\bsetprop\b`}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.checkCommand("setprop persist.sys.locale fr-FR"); err == nil {
+		t.Error("expected a configured blocked pattern to be applied")
+	}
+	policy.AllowBlockedCommands = true
+	if err := policy.checkCommand("reboot"); err != nil {
+		t.Errorf("expected blocked commands to be allowed, got %v", err)
+	}
+}
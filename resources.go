@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const resourceURIScheme = "android://"
+
+// resourceNotFoundCode is the MCP error code for an unknown resource URI
+const resourceNotFoundCode = -32002
+
+// deviceResource describes one kind of per-device resource, served at android://{serial}/{name}
+type deviceResource struct {
+	name        string
+	description string
+	mimeType    string
+	read        func(serial string) (ResourceContents, error)
+}
+
+// deviceResources lists the per-device resources in the order resources/list reports them
+var deviceResources = []deviceResource{
+	{
+		name:        "properties",
+		description: "All system properties of the device (getprop) as a JSON object",
+		mimeType:    "application/json",
+		read:        readPropertiesResource,
+	},
+	{
+		name:        "screenshot",
+		description: "Current screen contents, encoded with the configured screenshot defaults",
+		mimeType:    "image/png",
+		read:        readScreenshotResource,
+	},
+	{
+		name:        "ui",
+		description: "View hierarchy of the current screen (uiautomator dump) as XML",
+		mimeType:    "application/xml",
+		read:        readUIResource,
+	},
+	{
+		name:        "packages",
+		description: "Installed package names as a sorted JSON array",
+		mimeType:    "application/json",
+		read:        readPackagesResource,
+	},
+}
+
+func handleResourcesList(request JSONRPCRequest) {
+	devices, err := listAdbDevices(context.Background())
+	if err != nil {
+		sendError(request.ID, -32603, "Failed to get device list", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	resources := []Resource{}
+	policy := currentConfig().Policy
+	for _, device := range devices {
+		if device.RunStatus != "device" || policy.checkDevice(device.Device) != nil {
+			continue
+		}
+		name := deviceName(device, devices)
+		for _, kind := range deviceResources {
+			resources = append(resources, Resource{
+				URI:         resourceURIScheme + name + "/" + kind.name,
+				Name:        name + " " + kind.name,
+				Description: kind.description,
+				MimeType:    kind.mimeType,
+			})
+		}
+	}
+
+	sendResponse(JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  ResourcesListResult{Resources: resources},
+	})
+}
+
+func handleResourceTemplatesList(request JSONRPCRequest) {
+	templates := make([]ResourceTemplate, 0, len(deviceResources))
+	for _, kind := range deviceResources {
+		templates = append(templates, ResourceTemplate{
+			URITemplate: resourceURIScheme + "{serial}/" + kind.name,
+			Name:        "Device " + kind.name,
+			Description: kind.description,
+			MimeType:    kind.mimeType,
+		})
+	}
+
+	sendResponse(JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  ResourceTemplatesListResult{ResourceTemplates: templates},
+	})
+}
+
+func handleResourcesRead(request JSONRPCRequest) {
+	var params ResourcesReadParams
+	if request.Params != nil {
+		paramsBytes, _ := json.Marshal(request.Params)
+		if err := json.Unmarshal(paramsBytes, &params); err != nil {
+			sendError(request.ID, -32602, "Invalid params", nil)
+			return
+		}
+	}
+
+	serial, kind, err := parseResourceURI(params.URI)
+	if err != nil {
+		sendError(request.ID, resourceNotFoundCode, "Resource not found", map[string]interface{}{
+			"uri":   params.URI,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := currentConfig().Policy.checkDevice(serial); err != nil {
+		sendError(request.ID, -32603, err.Error(), map[string]interface{}{"uri": params.URI})
+		return
+	}
+
+	contents, err := kind.read(serial)
+	if err != nil {
+		message := err.Error()
+		if hint := adbErrorHint(message); hint != "" {
+			message += "\nHint: " + hint
+		}
+		sendError(request.ID, -32603, message, map[string]interface{}{"uri": params.URI})
+		return
+	}
+
+	contents.URI = params.URI
+	sendResponse(JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  ResourcesReadResult{Contents: []ResourceContents{contents}},
+	})
+}
+
+// parseResourceURI splits android://{serial}/{name}; serials such as "192.168.1.5:5555" may contain colons
+func parseResourceURI(uri string) (string, deviceResource, error) {
+	rest, found := strings.CutPrefix(uri, resourceURIScheme)
+	if !found {
+		return "", deviceResource{}, fmt.Errorf("resource URIs start with %s", resourceURIScheme)
+	}
+
+	index := strings.LastIndex(rest, "/")
+	if index <= 0 {
+		return "", deviceResource{}, fmt.Errorf("expected %s{serial}/{resource}", resourceURIScheme)
+	}
+	serial, name := rest[:index], rest[index+1:]
+
+	for _, kind := range deviceResources {
+		if kind.name == name {
+			return serial, kind, nil
+		}
+	}
+	return "", deviceResource{}, fmt.Errorf("unknown device resource %q", name)
+}
+
+func readPropertiesResource(serial string) (ResourceContents, error) {
+	output, err := outputWithTimeout(adbCommand(context.Background(), "-s", serial, "shell", "getprop"), currentConfig().deviceDetailsTimeout())
+	if err != nil {
+		return ResourceContents{}, fmt.Errorf("failed to read properties from device %s: %w%s", serial, err, commandStderr(err))
+	}
+
+	propertiesJSON, _ := json.MarshalIndent(parseGetprop(string(output)), "", "  ")
+	return ResourceContents{MimeType: "application/json", Text: string(propertiesJSON)}, nil
+}
+
+func readScreenshotResource(serial string) (ResourceContents, error) {
+	data, mimeType, err := captureScreenshot(context.Background(), serial, screenshotOptions(nil))
+	if err != nil {
+		return ResourceContents{}, err
+	}
+	return ResourceContents{MimeType: mimeType, Blob: data}, nil
+}
+
+func readUIResource(serial string) (ResourceContents, error) {
+	// Dumping to /dev/tty streams the XML back instead of leaving a file on the device
+	output, err := outputWithTimeout(adbCommand(context.Background(), "-s", serial, "exec-out", "uiautomator", "dump", "/dev/tty"), currentConfig().shellTimeout())
+	if err != nil {
+		return ResourceContents{}, fmt.Errorf("failed to dump UI hierarchy from device %s: %w%s", serial, err, commandStderr(err))
+	}
+
+	// uiautomator appends "UI hierchary dumped to: /dev/tty" after the XML
+	dump := string(output)
+	end := strings.LastIndex(dump, "</hierarchy>")
+	if end < 0 {
+		return ResourceContents{}, fmt.Errorf("failed to dump UI hierarchy from device %s: %s", serial, strings.TrimSpace(dump))
+	}
+	return ResourceContents{MimeType: "application/xml", Text: dump[:end+len("</hierarchy>")]}, nil
+}
+
+func readPackagesResource(serial string) (ResourceContents, error) {
+	output, err := outputWithTimeout(adbCommand(context.Background(), "-s", serial, "shell", "pm", "list", "packages"), currentConfig().shellTimeout())
+	if err != nil {
+		return ResourceContents{}, fmt.Errorf("failed to list packages on device %s: %w%s", serial, err, commandStderr(err))
+	}
+
+	packages := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		name, found := strings.CutPrefix(strings.TrimSpace(line), "package:")
+		// Packages the policy refuses are left out, as if they were not installed
+		if found && currentConfig().Policy.checkPackage(name) == nil {
+			packages = append(packages, name)
+		}
+	}
+	sort.Strings(packages)
+
+	packagesJSON, _ := json.MarshalIndent(packages, "", "  ")
+	return ResourceContents{MimeType: "application/json", Text: string(packagesJSON)}, nil
+}
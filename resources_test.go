@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResources(t *testing.T) {
+	var response JSONRPCResponse
+	originalSendResponse := sendResponse
+	sendResponse = func(resp JSONRPCResponse) { response = resp }
+	originalExecCommand := execCommand
+	execCommand = helperCommand
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) { return file, nil }
+	defer func() {
+		sendResponse = originalSendResponse
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+	}()
+
+	t.Run("List", func(t *testing.T) {
+		handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "resources/list"})
+		result, ok := response.Result.(ResourcesListResult)
+		if !ok || len(result.Resources) != len(deviceResources) {
+			t.Fatalf("expected one resource per kind for emulator-5554, got %+v", response)
+		}
+		if result.Resources[0].URI != "android://emulator-5554/properties" || result.Resources[0].MimeType != "application/json" {
+			t.Errorf("unexpected first resource: %+v", result.Resources[0])
+		}
+	})
+
+	t.Run("Templates", func(t *testing.T) {
+		handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 2, Method: "resources/templates/list"})
+		result, ok := response.Result.(ResourceTemplatesListResult)
+		if !ok || result.ResourceTemplates[2].URITemplate != "android://{serial}/ui" {
+			t.Errorf("unexpected templates: %+v", response)
+		}
+	})
+
+	tests := []struct {
+		uri      string
+		mimeType string
+		contains string
+	}{
+		{"android://emulator-5554/properties", "application/json", `"ro.build.version.sdk": "30"`},
+		{"android://emulator-5554/ui", "application/xml", `<node text="Settings" /></hierarchy>`},
+		{"android://emulator-5554/packages", "application/json", "[\n  \"com.android.settings\",\n  \"com.google.android.youtube\"\n]"},
+	}
+	for _, test := range tests {
+		t.Run(test.uri, func(t *testing.T) {
+			handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 3, Method: "resources/read", Params: map[string]interface{}{"uri": test.uri}})
+			result, ok := response.Result.(ResourcesReadResult)
+			if !ok || len(result.Contents) != 1 {
+				t.Fatalf("expected one content item, got %+v", response)
+			}
+			contents := result.Contents[0]
+			if contents.URI != test.uri || contents.MimeType != test.mimeType || !strings.Contains(contents.Text, test.contains) {
+				t.Errorf("unexpected contents: %+v", contents)
+			}
+			if strings.HasSuffix(test.uri, "/ui") && strings.Contains(contents.Text, "dumped to") {
+				t.Errorf("expected uiautomator status line to be stripped: %q", contents.Text)
+			}
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 4, Method: "resources/read", Params: map[string]interface{}{"uri": "android://emulator-5554/contacts"}})
+		if response.Error == nil || response.Error.Code != resourceNotFoundCode {
+			t.Errorf("expected resource not found error, got %+v", response)
+		}
+	})
+
+	t.Run("DeniedPackages", func(t *testing.T) {
+		useConfig(t, &Config{Policy: PolicyConfig{DeniedPackages: []string{"com.android.*"}}})
+		handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 5, Method: "resources/read", Params: map[string]interface{}{"uri": "android://emulator-5554/packages"}})
+		result, ok := response.Result.(ResourcesReadResult)
+		if !ok || result.Contents[0].Text != "[\n  \"com.google.android.youtube\"\n]" {
+			t.Errorf("expected denied packages to be left out, got %+v", response)
+		}
+	})
+
+	t.Run("DeniedDevice", func(t *testing.T) {
+		useConfig(t, &Config{Policy: PolicyConfig{DeniedDevices: []string{"emulator-*"}}})
+		handleRequest(JSONRPCRequest{JSONRPC: "2.0", ID: 5, Method: "resources/read", Params: map[string]interface{}{"uri": "android://emulator-5554/packages"}})
+		if response.Error == nil || !strings.Contains(response.Error.Message, "blocked by safety policy") {
+			t.Errorf("expected policy error, got %+v", response)
+		}
+	})
+}
+
+func TestParseResourceURI(t *testing.T) {
+	serial, kind, err := parseResourceURI("android://192.168.1.5:5555/screenshot")
+	if err != nil || serial != "192.168.1.5:5555" || kind.name != "screenshot" {
+		t.Errorf("unexpected parse result: %s, %s, %v", serial, kind.name, err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// validateSchema checks a decoded JSON value against the subset of JSON Schema used by tool input schemas:
+// type, properties, required, additionalProperties, enum, minimum, maximum, items, minItems and maxItems.
+func validateSchema(schema map[string]interface{}, value interface{}, path string) error {
+	if schema == nil {
+		return nil
+	}
+
+	if schemaType, ok := schema["type"].(string); ok {
+		if !matchesSchemaType(schemaType, value) {
+			return fmt.Errorf("%s must be of type %s", describeSchemaPath(path), schemaType)
+		}
+	}
+
+	if enum := schemaList(schema["enum"]); enum != nil {
+		found := false
+		for _, allowed := range enum {
+			if schemaValuesEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			options := make([]string, len(enum))
+			for i, allowed := range enum {
+				options[i] = fmt.Sprint(allowed)
+			}
+			return fmt.Errorf("%s must be one of: %s", describeSchemaPath(path), strings.Join(options, ", "))
+		}
+	}
+
+	if number, ok := schemaNumber(value); ok {
+		if minimum, ok := schemaNumber(schema["minimum"]); ok && number < minimum {
+			return fmt.Errorf("%s must be >= %v", describeSchemaPath(path), minimum)
+		}
+		if maximum, ok := schemaNumber(schema["maximum"]); ok && number > maximum {
+			return fmt.Errorf("%s must be <= %v", describeSchemaPath(path), maximum)
+		}
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return validateSchemaObject(schema, typed, path)
+	case []interface{}:
+		if minItems, ok := schemaNumber(schema["minItems"]); ok && float64(len(typed)) < minItems {
+			return fmt.Errorf("%s must have at least %v items", describeSchemaPath(path), minItems)
+		}
+		if maxItems, ok := schemaNumber(schema["maxItems"]); ok && float64(len(typed)) > maxItems {
+			return fmt.Errorf("%s must have at most %v items", describeSchemaPath(path), maxItems)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range typed {
+				if err := validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateSchemaObject(schema map[string]interface{}, object map[string]interface{}, path string) error {
+	for _, required := range schemaList(schema["required"]) {
+		name, _ := required.(string)
+		if _, exists := object[name]; !exists {
+			return fmt.Errorf("%s is required", describeSchemaPath(joinSchemaPath(path, name)))
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	additionalAllowed := true
+	if additional, ok := schema["additionalProperties"].(bool); ok {
+		additionalAllowed = additional
+	}
+
+	// Validate in a stable order so error messages are deterministic
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		propertySchema, known := properties[name].(map[string]interface{})
+		if !known {
+			if !additionalAllowed {
+				return fmt.Errorf("unknown argument %s", joinSchemaPath(path, name))
+			}
+			continue
+		}
+		if err := validateSchema(propertySchema, object[name], joinSchemaPath(path, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchesSchemaType(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := schemaNumber(value)
+		return ok
+	case "integer":
+		number, ok := schemaNumber(value)
+		return ok && number == math.Trunc(number)
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func schemaNumber(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case int:
+		return float64(number), true
+	}
+	return 0, false
+}
+
+// schemaList accepts both the []interface{} produced by encoding/json and the typed slices used in Go literals
+func schemaList(value interface{}) []interface{} {
+	switch list := value.(type) {
+	case []interface{}:
+		return list
+	case []string:
+		result := make([]interface{}, len(list))
+		for i, item := range list {
+			result[i] = item
+		}
+		return result
+	}
+	return nil
+}
+
+func schemaValuesEqual(a, b interface{}) bool {
+	if numberA, ok := schemaNumber(a); ok {
+		numberB, ok := schemaNumber(b)
+		return ok && numberA == numberB
+	}
+	return a == b
+}
+
+func joinSchemaPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func describeSchemaPath(path string) string {
+	if path == "" {
+		return "arguments"
+	}
+	return path
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"time"
+)
+
+var screenshotTimeout = 30 * time.Second
+
+const defaultJPEGQuality = 80
+
+// ScreenshotOptions controls how a captured screenshot is encoded
+type ScreenshotOptions struct {
+	Format       string
+	JPEGQuality  int
+	MaxDimension int
+}
+
+// screenshotOptions merges tool arguments over the configured screenshot defaults
+func screenshotOptions(arguments map[string]interface{}) ScreenshotOptions {
+	defaults := currentConfig().Screenshot
+	options := ScreenshotOptions{
+		Format:       defaults.Format,
+		JPEGQuality:  defaults.JPEGQuality,
+		MaxDimension: defaults.MaxDimension,
+	}
+
+	if format := getStringArgument(arguments, "format"); format != "" {
+		options.Format = format
+	}
+	if quality, ok := getNumberArgument(arguments, "jpeg_quality"); ok {
+		options.JPEGQuality = int(quality)
+	}
+	if maxDimension, ok := getNumberArgument(arguments, "max_dimension"); ok {
+		options.MaxDimension = int(maxDimension)
+	}
+
+	if options.Format == "" {
+		options.Format = "png"
+	}
+	if options.JPEGQuality == 0 {
+		options.JPEGQuality = defaultJPEGQuality
+	}
+	return options
+}
+
+// captureScreenshot returns the base64-encoded screenshot and its MIME type
+func captureScreenshot(ctx context.Context, deviceName string, options ScreenshotOptions) (string, string, error) {
+	// Use exec-out to stream screenshot data directly from device to PC
+	// This avoids creating temporary files on the Android device
+	screenshotCmd := adbCommand(ctx, "-s", deviceName, "exec-out", "screencap", "-p")
+	imageData, err := outputWithTimeout(screenshotCmd, currentConfig().screenshotTimeout())
+	if err != nil {
+		return "", "", fmt.Errorf("failed to capture screenshot from device %s: %w%s", deviceName, err, commandStderr(err))
+	}
+
+	imageData, mimeType, err := encodeScreenshot(imageData, options)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode screenshot from device %s: %w", deviceName, err)
+	}
+
+	// Encode to base64
+	base64Data := base64.StdEncoding.EncodeToString(imageData)
+	return base64Data, mimeType, nil
+}
+
+// encodeScreenshot re-encodes the PNG from screencap when a different format or size is requested
+func encodeScreenshot(pngData []byte, options ScreenshotOptions) ([]byte, string, error) {
+	if options.Format != "jpeg" && options.MaxDimension <= 0 {
+		return pngData, "image/png", nil
+	}
+
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		return nil, "", err
+	}
+	if options.MaxDimension > 0 {
+		img = scaleImage(img, options.MaxDimension)
+	}
+
+	var encoded bytes.Buffer
+	if options.Format == "jpeg" {
+		if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: options.JPEGQuality}); err != nil {
+			return nil, "", err
+		}
+		return encoded.Bytes(), "image/jpeg", nil
+	}
+
+	if err := png.Encode(&encoded, img); err != nil {
+		return nil, "", err
+	}
+	return encoded.Bytes(), "image/png", nil
+}
+
+// scaleImage shrinks img with nearest-neighbor sampling so its longer side is at most maxDimension
+func scaleImage(img image.Image, maxDimension int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	longest := width
+	if height > longest {
+		longest = height
+	}
+	if longest <= maxDimension {
+		return img
+	}
+
+	scaledWidth := max(1, width*maxDimension/longest)
+	scaledHeight := max(1, height*maxDimension/longest)
+
+	source := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(source, source.Bounds(), img, bounds.Min, draw.Src)
+
+	scaled := image.NewRGBA(image.Rect(0, 0, scaledWidth, scaledHeight))
+	for y := 0; y < scaledHeight; y++ {
+		sourceY := y * height / scaledHeight
+		for x := 0; x < scaledWidth; x++ {
+			sourceX := x * width / scaledWidth
+			sourceOffset := source.PixOffset(sourceX, sourceY)
+			copy(scaled.Pix[scaled.PixOffset(x, y):], source.Pix[sourceOffset:sourceOffset+4])
+		}
+	}
+	return scaled
+}
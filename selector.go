@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// selectorOperators are checked longest first so ">=" is not mistaken for "="
+var selectorOperators = []string{">=", "<=", "!=", "=", ">", "<"}
+
+// selectorAliases maps short filter keys to Device JSON field names
+var selectorAliases = map[string]string{
+	"sdk":        "sdk_level",
+	"android":    "android_version",
+	"version":    "android_version",
+	"serial":     "device",
+	"status":     "run_status",
+	"connection": "connection_type",
+	"battery":    "battery_level",
+	"abi":        "abis",
+	"transport":  "transport_id",
+}
+
+// deviceCondition is one "key<op>value" term of a filter selector
+type deviceCondition struct {
+	key      string
+	operator string
+	value    string
+}
+
+// resolveDeviceSelector picks one device serial for a selector: a serial, transport id, AVD name,
+// model substring, or a comma-separated filter such as "sdk>=33,emulator=true".
+// Configured aliases are expanded first; an empty selector uses the configured default device,
+// or else the first ready device.
+func resolveDeviceSelector(ctx context.Context, selector string, emulatorOnly bool) (string, error) {
+	config := currentConfig()
+	selector = strings.TrimSpace(selector)
+	if selector == "" {
+		selector = config.DefaultDevice
+	}
+	if aliased, ok := config.DeviceAliases[selector]; ok {
+		selector = aliased
+	}
+
+	devices, err := listAdbDevices(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get device list: %w", err)
+	}
+	// Names are qualified against every listed device, so a serial on two servers is never ambiguous
+	all := devices
+	name := func(device Device) string { return deviceName(device, all) }
+
+	if emulatorOnly {
+		devices = filterDevices(devices, func(device Device) bool {
+			return device.ConnectionType == "emulator"
+		})
+	}
+
+	// Exact serials and transport ids need no device details, but may repeat across adb servers
+	if selector != "" {
+		exact := filterDevices(devices, func(device Device) bool {
+			return device.Device == selector || device.qualifiedName() == selector ||
+				(device.TransportID != "" && device.TransportID == selector)
+		})
+		switch {
+		case len(exact) == 1:
+			return name(exact[0]), nil
+		case len(exact) > 1:
+			return "", fmt.Errorf("device selector %q matches devices on several adb servers; add @host to pick one%s", selector, describeCandidates(exact, all))
+		}
+		if emulatorOnly {
+			if _, err := emulatorConsolePort(selector); err == nil {
+				return "", fmt.Errorf("emulator %s not found", selector)
+			}
+		}
+	}
+
+	ready := func(device Device) bool { return device.RunStatus == "device" }
+
+	if selector == "" {
+		candidates := filterDevices(devices, ready)
+		if len(candidates) == 0 {
+			if emulatorOnly {
+				return "", fmt.Errorf("no Android emulators found%s", describeCandidates(devices, all))
+			}
+			return "", fmt.Errorf("no Android devices found%s", describeCandidates(devices, all))
+		}
+		return name(candidates[0]), nil
+	}
+
+	fetchDeviceDetails(ctx, devices)
+
+	matches, err := matchDeviceSelector(selector, devices)
+	if err != nil {
+		return "", err
+	}
+
+	readyMatches := filterDevices(matches, ready)
+	switch {
+	case len(readyMatches) == 1:
+		return name(readyMatches[0]), nil
+	case len(readyMatches) > 1:
+		return "", fmt.Errorf("device selector %q matches several devices%s", selector, describeCandidates(readyMatches, all))
+	case len(matches) > 0:
+		return "", fmt.Errorf("device selector %q only matches devices that are not ready%s", selector, describeCandidates(matches, all))
+	}
+	return "", fmt.Errorf("no device matches selector %q%s", selector, describeCandidates(devices, all))
+}
+
+// matchDeviceSelector returns the devices matching a filter expression, AVD name or model substring
+func matchDeviceSelector(selector string, devices []Device) ([]Device, error) {
+	conditions, isFilter, err := parseDeviceFilter(selector)
+	if err != nil {
+		return nil, err
+	}
+	if isFilter {
+		return filterDevices(devices, func(device Device) bool {
+			values := deviceSelectorValues(device)
+			for _, condition := range conditions {
+				if !condition.matches(values) {
+					return false
+				}
+			}
+			return true
+		}), nil
+	}
+
+	// AVD names use underscores where the device name has spaces
+	avdName := strings.ToLower(strings.ReplaceAll(selector, "_", " "))
+	matches := filterDevices(devices, func(device Device) bool {
+		return device.ConnectionType == "emulator" && strings.ToLower(device.Name) == avdName
+	})
+	if len(matches) > 0 {
+		return matches, nil
+	}
+
+	needle := strings.ToLower(selector)
+	return filterDevices(devices, func(device Device) bool {
+		return strings.Contains(strings.ToLower(device.Model), needle) || strings.Contains(strings.ToLower(device.Name), needle)
+	}), nil
+}
+
+// parseDeviceFilter parses "key<op>value" terms; isFilter is false when the selector is not a filter expression
+func parseDeviceFilter(selector string) ([]deviceCondition, bool, error) {
+	knownKeys := make(map[string]bool)
+	for _, name := range deviceFieldNames() {
+		knownKeys[name] = true
+	}
+	knownKeys["emulator"] = true
+
+	var conditions []deviceCondition
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		condition, found := splitDeviceCondition(term)
+		if !found {
+			if len(conditions) == 0 {
+				return nil, false, nil
+			}
+			return nil, true, fmt.Errorf("invalid device filter term %q", term)
+		}
+
+		if alias, ok := selectorAliases[condition.key]; ok {
+			condition.key = alias
+		}
+		if !knownKeys[condition.key] {
+			if len(conditions) == 0 {
+				return nil, false, nil
+			}
+			return nil, true, fmt.Errorf("unknown device filter key %q", condition.key)
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, true, nil
+}
+
+func splitDeviceCondition(term string) (deviceCondition, bool) {
+	for _, operator := range selectorOperators {
+		if key, value, found := strings.Cut(term, operator); found {
+			return deviceCondition{
+				key:      strings.ToLower(strings.TrimSpace(key)),
+				operator: operator,
+				value:    strings.TrimSpace(value),
+			}, true
+		}
+	}
+	return deviceCondition{}, false
+}
+
+// deviceSelectorValues flattens a device into the string values filter conditions compare against
+func deviceSelectorValues(device Device) map[string][]string {
+	var fields map[string]interface{}
+	deviceJSON, _ := json.Marshal(device)
+	json.Unmarshal(deviceJSON, &fields)
+
+	values := make(map[string][]string)
+	for key, value := range fields {
+		switch typed := value.(type) {
+		case []interface{}:
+			for _, item := range typed {
+				values[key] = append(values[key], fmt.Sprint(item))
+			}
+		default:
+			values[key] = []string{fmt.Sprint(typed)}
+		}
+	}
+	values["emulator"] = []string{strconv.FormatBool(device.ConnectionType == "emulator")}
+	return values
+}
+
+// matches reports whether any of the field's values satisfies the condition, or for "!=" whether none equals it
+func (c deviceCondition) matches(values map[string][]string) bool {
+	if c.operator == "!=" {
+		for _, actual := range values[c.key] {
+			if compareSelectorValues(actual, "=", c.value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, actual := range values[c.key] {
+		if compareSelectorValues(actual, c.operator, c.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareSelectorValues compares numerically when both sides are numbers, otherwise case-insensitively
+func compareSelectorValues(actual string, operator string, expected string) bool {
+	actualNumber, actualErr := strconv.ParseFloat(actual, 64)
+	expectedNumber, expectedErr := strconv.ParseFloat(expected, 64)
+	comparison := 0
+	if actualErr == nil && expectedErr == nil {
+		switch {
+		case actualNumber < expectedNumber:
+			comparison = -1
+		case actualNumber > expectedNumber:
+			comparison = 1
+		}
+	} else {
+		comparison = strings.Compare(strings.ToLower(actual), strings.ToLower(expected))
+	}
+
+	switch operator {
+	case "=":
+		return comparison == 0
+	case "!=":
+		return comparison != 0
+	case ">=":
+		return comparison >= 0
+	case "<=":
+		return comparison <= 0
+	case ">":
+		return comparison > 0
+	case "<":
+		return comparison < 0
+	}
+	return false
+}
+
+func filterDevices(devices []Device, keep func(Device) bool) []Device {
+	var filtered []Device
+	for _, device := range devices {
+		if keep(device) {
+			filtered = append(filtered, device)
+		}
+	}
+	return filtered
+}
+
+// describeCandidates lists devices as an error message suffix so the caller can pick one,
+// naming them as they would be selected among all
+func describeCandidates(devices []Device, all []Device) string {
+	if len(devices) == 0 {
+		return ""
+	}
+
+	descriptions := make([]string, 0, len(devices))
+	for _, device := range devices {
+		description := deviceName(device, all) + " (" + device.RunStatus
+		if device.Name != "" {
+			description += ", " + device.Name
+		}
+		descriptions = append(descriptions, description+")")
+	}
+	return "; candidates: " + strings.Join(descriptions, ", ")
+}
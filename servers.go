@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultADBServerPort is where adb servers listen unless told otherwise
+const defaultADBServerPort = 5037
+
+// adbServer is an adb server devices are listed from; the zero value is the client's default server
+type adbServer struct {
+	host string
+	port int
+}
+
+var (
+	deviceRoutesMutex sync.Mutex
+	// deviceRoutes maps serials to the adb server that listed them most recently
+	deviceRoutes = make(map[string]adbServer)
+)
+
+// parseADBServer accepts "host:port", "host", "port" or the "tcp:host:port" form of ADB_SERVER_SOCKET
+func parseADBServer(address string) (adbServer, error) {
+	address = strings.TrimPrefix(strings.TrimSpace(address), "tcp:")
+	if address == "" {
+		return adbServer{}, fmt.Errorf("adb server address must not be empty")
+	}
+
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		// A bare number is a port on this machine, anything else a host on the default port
+		if _, numberErr := strconv.Atoi(address); numberErr == nil {
+			host, portText = "", address
+		} else {
+			host, portText = address, ""
+		}
+	}
+
+	server := adbServer{host: host}
+	if portText != "" {
+		port, err := strconv.Atoi(portText)
+		if err != nil || port < 1 || port > 65535 {
+			return adbServer{}, fmt.Errorf("invalid adb server port in %q", address)
+		}
+		server.port = port
+	}
+	return server, nil
+}
+
+// args returns the global adb flags that select the server
+func (s adbServer) args() []string {
+	var args []string
+	if s.host != "" {
+		args = append(args, "-H", s.host)
+	}
+	if s.port != 0 {
+		args = append(args, "-P", strconv.Itoa(s.port))
+	}
+	return args
+}
+
+// String returns the server as host:port, the value of the device host field
+func (s adbServer) String() string {
+	host := s.host
+	if host == "" {
+		host = "localhost"
+	}
+	port := s.port
+	if port == 0 {
+		port = defaultADBServerPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// isLocal reports whether the server runs on this machine, where emulators are started and their consoles listen
+func (s adbServer) isLocal() bool {
+	switch s.host {
+	case "", "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+// adbServers returns the configured servers: adb_servers, else adb_server_host and adb_server_port,
+// else ADB_SERVER_SOCKET, else the default local server
+func (c *Config) adbServers() []adbServer {
+	if len(c.ADBServers) > 0 {
+		servers := make([]adbServer, 0, len(c.ADBServers))
+		for _, address := range c.ADBServers {
+			// Addresses were checked by Validate
+			server, _ := parseADBServer(address)
+			servers = append(servers, server)
+		}
+		return servers
+	}
+	if c.ADBServerHost != "" || c.ADBServerPort != 0 {
+		return []adbServer{{host: c.ADBServerHost, port: c.ADBServerPort}}
+	}
+	if socket := os.Getenv("ADB_SERVER_SOCKET"); socket != "" {
+		if server, err := parseADBServer(socket); err == nil {
+			return []adbServer{server}
+		}
+	}
+	return []adbServer{{}}
+}
+
+// deviceServer splits a device name into its serial and the adb server it is attached to.
+// The name is a serial, routed to the server that last listed it, or serial@host for a serial
+// that several servers have.
+func deviceServer(name string) (string, adbServer) {
+	servers := currentConfig().adbServers()
+	if index := strings.LastIndex(name, "@"); index >= 0 {
+		for _, server := range servers {
+			if server.String() == name[index+1:] {
+				return name[:index], server
+			}
+		}
+	}
+
+	deviceRoutesMutex.Lock()
+	defer deviceRoutesMutex.Unlock()
+	if server, ok := deviceRoutes[name]; ok {
+		return name, server
+	}
+	return name, servers[0]
+}
+
+// deviceSerial returns the serial of a device name, without the server qualifier
+func deviceSerial(name string) string {
+	serial, _ := deviceServer(name)
+	return serial
+}
+
+// qualifiedName names the device together with its adb server, so commands reach it even when another server has the same serial
+func (d Device) qualifiedName() string {
+	if d.Host == "" {
+		return d.Device
+	}
+	return d.Device + "@" + d.Host
+}
+
+// deviceName names the device for tools and resources: its serial, qualified as serial@host
+// only when a device on another server has the same serial
+func deviceName(device Device, devices []Device) string {
+	for _, other := range devices {
+		if other.Device == device.Device && other.Host != device.Host {
+			return device.qualifiedName()
+		}
+	}
+	return device.Device
+}
+
+// setDeviceRoutes remembers which server listed each serial; the first server wins for duplicates
+func setDeviceRoutes(devices []Device, servers map[string]adbServer) {
+	routes := make(map[string]adbServer, len(devices))
+	for _, device := range devices {
+		if _, exists := routes[device.Device]; !exists {
+			routes[device.Device] = servers[device.Host]
+		}
+	}
+
+	deviceRoutesMutex.Lock()
+	deviceRoutes = routes
+	deviceRoutesMutex.Unlock()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseADBServer(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+		args    string
+		wantErr string
+	}{
+		{"lab-1:5038", "lab-1:5038", "-H lab-1 -P 5038", ""},
+		{"tcp:10.0.0.7:5037", "10.0.0.7:5037", "-H 10.0.0.7 -P 5037", ""},
+		{"lab-1", "lab-1:5037", "-H lab-1", ""},
+		{"5039", "localhost:5039", "-P 5039", ""},
+		{"[::1]:5037", "[::1]:5037", "-H ::1 -P 5037", ""},
+		{"lab-1:99999", "", "", "invalid adb server port"},
+		{"", "", "", "must not be empty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			server, err := parseADBServer(test.address)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if server.String() != test.want || strings.Join(server.args(), " ") != test.args {
+				t.Errorf("got %s with args %q", server, server.args())
+			}
+		})
+	}
+}
+
+func TestDeviceName(t *testing.T) {
+	useConfig(t, &Config{ADBServers: []string{"lab-1:5037", "lab-2:5037"}})
+	devices := []Device{
+		{Device: "emulator-5554", Host: "lab-1:5037"},
+		{Device: "emulator-5554", Host: "lab-2:5037"},
+		{Device: "R5CT1234", Host: "lab-2:5037"},
+	}
+	t.Cleanup(func() { setDeviceRoutes(nil, nil) })
+	setDeviceRoutes(devices, map[string]adbServer{"lab-1:5037": {host: "lab-1", port: 5037}, "lab-2:5037": {host: "lab-2", port: 5037}})
+
+	if name := deviceName(devices[1], devices); name != "emulator-5554@lab-2:5037" {
+		t.Errorf("expected a qualified name for a duplicate serial, got %s", name)
+	}
+	if name := deviceName(devices[2], devices); name != "R5CT1234" {
+		t.Errorf("expected the plain serial, got %s", name)
+	}
+
+	if serial, server := deviceServer("emulator-5554@lab-2:5037"); serial != "emulator-5554" || server.host != "lab-2" {
+		t.Errorf("got %s on %s", serial, server)
+	}
+	if serial, server := deviceServer("R5CT1234"); serial != "R5CT1234" || server.host != "lab-2" {
+		t.Errorf("expected the phone to be routed to lab-2, got %s on %s", serial, server)
+	}
+	if serial, server := deviceServer("unknown@example"); serial != "unknown@example" || server.host != "lab-1" {
+		t.Errorf("expected an unknown device on the first server, got %s on %s", serial, server)
+	}
+}
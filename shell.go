@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var shellTimeout = 30 * time.Second
+
+const defaultShellMaxOutputBytes = 64 * 1024
+
+// shellExitMarker follows the command on devices without shell v2, which do not report the exit code
+const shellExitMarker = "--- mcp-android-devices exit "
+
+// shellSeparators split a command line into the simple commands whose programs are checked
+var shellSeparators = regexp.MustCompile(`[;&|\n]+`)
+
+// ShellConfig restricts android_shell by program name; the allowlist applies to every command in a pipeline or list
+type ShellConfig struct {
+	AllowedCommands []string `json:"allowed_commands,omitempty"`
+	DeniedCommands  []string `json:"denied_commands,omitempty"`
+	MaxOutputBytes  int      `json:"max_output_bytes,omitempty"`
+}
+
+// ShellResult is the outcome of an android_shell command
+type ShellResult struct {
+	Stdout        string `json:"stdout"`
+	Stderr        string `json:"stderr"`
+	ExitCode      int    `json:"exit_code"`
+	Truncated     bool   `json:"truncated"`
+	ShellProtocol string `json:"shell_protocol"`
+}
+
+func (c *ShellConfig) Validate() error {
+	if c.MaxOutputBytes < 0 {
+		return fmt.Errorf("shell.max_output_bytes must not be negative")
+	}
+	return nil
+}
+
+func (c *ShellConfig) maxOutputBytes() int {
+	if c.MaxOutputBytes > 0 {
+		return c.MaxOutputBytes
+	}
+	return defaultShellMaxOutputBytes
+}
+
+// checkCommand applies the allowlist and denylist to the programs of each simple command in the command line,
+// including the programs run through wrappers such as env, busybox or sh -c
+func (c *ShellConfig) checkCommand(command string) error {
+	if len(c.AllowedCommands) == 0 && len(c.DeniedCommands) == 0 {
+		return nil
+	}
+	// The programs of substitutions cannot be checked reliably, so refuse them outright
+	if strings.Contains(command, "$(") || strings.Contains(command, "`") || strings.Contains(command, "<(") || strings.Contains(command, ">(") {
+		return &policyError{"command substitution is not allowed when shell.allowed_commands or shell.denied_commands is set"}
+	}
+
+	for _, segment := range shellSeparators.Split(command, -1) {
+		for _, program := range commandPrograms(segment) {
+			for _, denied := range c.DeniedCommands {
+				if program == denied {
+					return &policyError{fmt.Sprintf("program %s is in shell.denied_commands", program)}
+				}
+			}
+			if len(c.AllowedCommands) > 0 && !containsString(c.AllowedCommands, program) {
+				return &policyError{fmt.Sprintf("program %s is not in shell.allowed_commands (%s)", program, strings.Join(c.AllowedCommands, ", "))}
+			}
+		}
+	}
+	return nil
+}
+
+// shellWordCleaner removes the quoting and grouping that do not change which program a word names
+var shellWordCleaner = strings.NewReplacer(`"`, "", `'`, "", `\`, "", "(", "", ")", "", "{", "", "}", "", "!", "")
+
+// shellAssignment matches a leading VAR=value word
+var shellAssignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// shellWrapperOptions lists, for programs that run another program, their options taking a value
+var shellWrapperOptions = map[string][]string{
+	"env":     {"-u", "-C"},
+	"nice":    {"-n"},
+	"nohup":   nil,
+	"exec":    {"-a"},
+	"command": nil,
+	"time":    nil,
+	"timeout": {"-s", "-k"},
+	"xargs":   {"-n", "-I", "-i", "-s", "-P", "-L", "-l", "-E", "-e", "-d", "-a"},
+	"busybox": nil,
+	"toybox":  nil,
+}
+
+// commandPrograms returns the program a simple command runs, followed by the programs it runs through wrappers
+func commandPrograms(segment string) []string {
+	var words []string
+	for _, field := range strings.Fields(segment) {
+		if word := shellWordCleaner.Replace(field); word != "" {
+			words = append(words, word)
+		}
+	}
+
+	var programs []string
+	for len(words) > 0 {
+		for len(words) > 0 && shellAssignment.MatchString(words[0]) {
+			words = words[1:]
+		}
+		if len(words) == 0 {
+			break
+		}
+
+		program := path.Base(words[0])
+		programs = append(programs, program)
+		words = words[1:]
+
+		switch program {
+		case "sh", "bash", "mksh", "ash":
+			// sh -c runs its argument as a command line
+			for i, word := range words {
+				if word == "-c" {
+					for _, nested := range shellSeparators.Split(strings.Join(words[i+1:], " "), -1) {
+						programs = append(programs, commandPrograms(nested)...)
+					}
+					break
+				}
+			}
+			return programs
+		}
+
+		valueOptions, wrapper := shellWrapperOptions[program]
+		if !wrapper {
+			return programs
+		}
+		// Skip the wrapper's options to reach the program it runs; timeout also takes a duration first
+		for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+			if containsString(valueOptions, words[0]) && len(words) > 1 {
+				words = words[1:]
+			}
+			words = words[1:]
+		}
+		if program == "timeout" && len(words) > 0 {
+			words = words[1:]
+		}
+	}
+	return programs
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceSupportsShellV2 reports whether adb and the device support the shell v2 protocol,
+// which keeps stderr separate and forwards the exit code
+func deviceSupportsShellV2(ctx context.Context, deviceName string) bool {
+	output, err := outputWithTimeout(adbCommand(ctx, "-s", deviceName, "features"), currentConfig().shellTimeout())
+	if err != nil {
+		return false
+	}
+	for _, feature := range strings.Split(strings.TrimSpace(string(output)), ",") {
+		if strings.TrimSpace(feature) == "shell_v2" {
+			return true
+		}
+	}
+	return false
+}
+
+// runShellCommand runs command on the device, falling back to an exit code marker on devices without shell v2
+func runShellCommand(ctx context.Context, deviceName string, command string, timeout time.Duration) (ShellResult, error) {
+	limit := currentConfig().Shell.maxOutputBytes()
+	stdout := &limitedBuffer{limit: limit}
+	stderr := &limitedBuffer{limit: limit}
+
+	result := ShellResult{ShellProtocol: "v2"}
+	shellCommand := command
+	if !deviceSupportsShellV2(ctx, deviceName) {
+		result.ShellProtocol = "legacy"
+		shellCommand = legacyShellCommand(command)
+	}
+
+	cmd := adbCommand(ctx, "-s", deviceName, "shell", shellCommand)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := runWithDeadline(cmd, timeout)
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && result.ShellProtocol == "legacy":
+		// Without shell v2 adb exits 0 whatever the command does, so a failure is adb's own
+		return ShellResult{}, fmt.Errorf("failed to run shell command on device %s: %w, output: %s", deviceName, err, strings.TrimSpace(stderr.String()+stdout.String()))
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		return ShellResult{}, fmt.Errorf("failed to run shell command on device %s: %w", deviceName, err)
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.Truncated = stdout.truncated > 0 || stderr.truncated > 0
+
+	if result.ShellProtocol == "legacy" {
+		// The marker line carries the command's exit code; -1 means truncation dropped the marker
+		result.ExitCode = -1
+		index := strings.LastIndex(result.Stdout, shellExitMarker)
+		if index < 0 && stdout.truncated == 0 {
+			return ShellResult{}, fmt.Errorf("adb did not report the exit code of the shell command on device %s, output: %s", deviceName, strings.TrimSpace(result.Stdout))
+		}
+		if index >= 0 {
+			code := strings.TrimSpace(result.Stdout[index+len(shellExitMarker):])
+			if exitCode, err := strconv.Atoi(code); err == nil {
+				result.ExitCode = exitCode
+			}
+			result.Stdout = result.Stdout[:index]
+		}
+	}
+
+	if stdout.truncated > 0 {
+		result.Stdout += truncationMarker(stdout.truncated)
+	}
+	if stderr.truncated > 0 {
+		result.Stderr += truncationMarker(stderr.truncated)
+	}
+	return result, nil
+}
+
+// legacyShellCommand runs command in a subshell ending on its own line, so a trailing comment, "&" or exit
+// cannot swallow or skip the exit code marker
+func legacyShellCommand(command string) string {
+	return "(" + command + "\n); echo \"" + shellExitMarker + "$?\""
+}
+
+func truncationMarker(dropped int) string {
+	return fmt.Sprintf("\n[output truncated: %d more bytes not shown]", dropped)
+}
+
+// limitedBuffer is an io.Writer that retains only the first limit bytes written to it and counts the rest
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		b.truncated += len(p) - max(room, 0)
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
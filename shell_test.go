@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellCheckCommand(t *testing.T) {
+	config := ShellConfig{
+		AllowedCommands: []string{"dumpsys", "grep", "getprop"},
+		DeniedCommands:  []string{"su"},
+	}
+
+	tests := []struct {
+		command string
+		wantErr string
+	}{
+		{"dumpsys battery | grep level", ""},
+		{"/system/bin/getprop ro.build.version.sdk", ""},
+		{"dumpsys battery; pm list packages", "program pm is not in shell.allowed_commands"},
+		{"getprop && su -c id", "program su is in shell.denied_commands"},
+		{"getprop $(cat /data/secret)", "command substitution is not allowed"},
+		{"getprop `cat /data/secret`", "command substitution is not allowed"},
+		{"grep x <(getprop)", "command substitution is not allowed"},
+		{"(su -c id)", "program su is in shell.denied_commands"},
+		{"{ su; }", "program su is in shell.denied_commands"},
+		{`"su" -c id`, "program su is in shell.denied_commands"},
+		{`\su`, "program su is in shell.denied_commands"},
+		{"FOO=1 su", "program su is in shell.denied_commands"},
+		{"env -u PATH FOO=1 su", "program env is not in shell.allowed_commands"},
+		{"getprop | xargs -n 1 su", "program xargs is not in shell.allowed_commands"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			err := config.checkCommand(test.command)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("expected error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestShellDeniedCommands(t *testing.T) {
+	config := ShellConfig{DeniedCommands: []string{"rm"}}
+
+	for _, command := range []string{
+		"(rm -r x)", "{ rm x; }", `"rm" x`, `\rm x`, "FOO=1 rm x", "env rm x", "env -i FOO=1 rm x",
+		"busybox rm x", "toybox rm x", "sh -c 'rm x'", "sh -c 'ls; rm x'", "nice -n 5 rm x", "timeout 5 rm x",
+		"ls | xargs -n 1 rm", "ls $(rm x)", "ls `rm x`",
+	} {
+		if err := config.checkCommand(command); err == nil {
+			t.Errorf("expected %q to be refused", command)
+		}
+	}
+	for _, command := range []string{"ls -l /sdcard", "env FOO=1 ls", "sh -c 'ls /sdcard'", "busybox ls"} {
+		if err := config.checkCommand(command); err != nil {
+			t.Errorf("expected %q to be allowed, got %v", command, err)
+		}
+	}
+}
+
+func TestRunShellCommand(t *testing.T) {
+	var features string
+	originalExecCommand := execCommand
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		cmd := helperCommand(command, args...)
+		cmd.Env = append(cmd.Env, "HELPER_FEATURES="+features)
+		return cmd
+	}
+	defer func() { execCommand = originalExecCommand }()
+
+	t.Run("ShellV2", func(t *testing.T) {
+		features = "shell_v2,cmd"
+		result, err := runShellCommand(context.Background(), "emulator-5554", "fail", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ShellResult{Stdout: "out\n", Stderr: "err\n", ExitCode: 3, ShellProtocol: "v2"}
+		if result != want {
+			t.Errorf("expected %+v, got %+v", want, result)
+		}
+	})
+
+	t.Run("Legacy", func(t *testing.T) {
+		features = "cmd"
+		result, err := runShellCommand(context.Background(), "emulator-5554", "fail", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ShellResult{Stdout: "out\nerr\n", ExitCode: 3, ShellProtocol: "legacy"}
+		if result != want {
+			t.Errorf("expected %+v, got %+v", want, result)
+		}
+	})
+
+	t.Run("LegacyADBError", func(t *testing.T) {
+		features = "cmd"
+		if _, err := runShellCommand(context.Background(), "emulator-5554", "offline", 5*time.Second); err == nil || !strings.Contains(err.Error(), "error: device offline") {
+			t.Errorf("expected adb's error, got %v", err)
+		}
+		if _, err := runShellCommand(context.Background(), "emulator-5554", "disconnect", 5*time.Second); err == nil || !strings.Contains(err.Error(), "did not report the exit code") {
+			t.Errorf("expected a missing marker to be an error, got %v", err)
+		}
+	})
+
+	t.Run("LegacyCommandEnding", func(t *testing.T) {
+		// The device shell is sh, so the host's sh shows whether the marker survives the command's ending
+		for command, want := range map[string]string{"true # comment": "0", "false &": "0", "exit 5": "5"} {
+			output, err := exec.Command("sh", "-c", legacyShellCommand(command)).Output()
+			if err != nil {
+				t.Fatalf("%q: %v", command, err)
+			}
+			if got := strings.TrimSpace(string(output)); got != shellExitMarker+want {
+				t.Errorf("%q: expected the marker with exit code %s, got %q", command, want, got)
+			}
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		features = "shell_v2"
+		useConfig(t, &Config{Shell: ShellConfig{MaxOutputBytes: 10}})
+		result, err := runShellCommand(context.Background(), "emulator-5554", "yes", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Truncated || result.Stdout != "y\ny\ny\ny\ny\n\n[output truncated: 990 more bytes not shown]" {
+			t.Errorf("expected truncated output, got %+v", result)
+		}
+	})
+}
@@ -48,5 +48,10 @@ $unknownResponse = $unknownRequest | .\mcp_android_devices.exe
 Write-Host "Request: $unknownRequest" -ForegroundColor Gray
 Write-Host "Response: $unknownResponse" -ForegroundColor White
 
+# Test 6: Command-line mode
+Write-Host "`n=== Test 6: Command-Line Mode ===" -ForegroundColor Cyan
+.\mcp_android_devices.exe devices
+.\mcp_android_devices.exe devices -json
+
 Write-Host "`n=== Testing Complete ===" -ForegroundColor Green
 Write-Host "All tests executed. Check responses above for correctness." -ForegroundColor Yellow
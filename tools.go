@@ -0,0 +1,895 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var toolRegistry = newBuiltinToolRegistry()
+
+// newBuiltinToolRegistry declares every tool the server exposes, in the order tools/list reports them
+func newBuiltinToolRegistry() *ToolRegistry {
+	registry := NewToolRegistry()
+
+	registry.Register(ToolDefinition{
+		Name:        "get_android_devices",
+		Description: "Get a list of connected Android devices and emulators",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"fields": map[string]interface{}{
+					"type": "array",
+					"items": map[string]interface{}{
+						"type": "string",
+						"enum": deviceFieldNames(),
+					},
+					"description": "Device fields to return (the device serial is always included). If not provided, all fields are returned.",
+				},
+			},
+		},
+		OutputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"devices": map[string]interface{}{
+					"type": "array",
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"name":            map[string]interface{}{"type": "string"},
+							"device":          map[string]interface{}{"type": "string"},
+							"model":           map[string]interface{}{"type": "string"},
+							"arch":            map[string]interface{}{"type": "string"},
+							"android_version": map[string]interface{}{"type": "string"},
+							"sdk_level":       map[string]interface{}{"type": "string"},
+							"run_status":      map[string]interface{}{"type": "string"},
+							"transport_id":    map[string]interface{}{"type": "string"},
+							"connection_type": map[string]interface{}{"type": "string", "enum": []string{"usb", "tcp", "emulator"}},
+							"manufacturer":    map[string]interface{}{"type": "string"},
+							"fingerprint":     map[string]interface{}{"type": "string"},
+							"security_patch":  map[string]interface{}{"type": "string"},
+							"screen_size":     map[string]interface{}{"type": "string"},
+							"screen_density":  map[string]interface{}{"type": "string"},
+							"battery_level":   map[string]interface{}{"type": "string"},
+							"locale":          map[string]interface{}{"type": "string"},
+							"boot_completed":  map[string]interface{}{"type": "boolean"},
+							"abis": map[string]interface{}{
+								"type":  "array",
+								"items": map[string]interface{}{"type": "string"},
+							},
+						},
+						"required": []string{"device"},
+					},
+				},
+			},
+			"required": []string{"devices"},
+		},
+		Device:  DeviceNone,
+		Safety:  SafetyReadOnly,
+		Handler: handleGetDevices,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:        "get_android_screen",
+		Description: "Capture a screenshot from an Android device",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"format": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"png", "jpeg"},
+					"description": "Image format (default png, or the configured default)",
+				},
+				"jpeg_quality": map[string]interface{}{
+					"type":        "integer",
+					"minimum":     1,
+					"maximum":     100,
+					"description": "JPEG quality when format is jpeg (default 80)",
+				},
+				"max_dimension": map[string]interface{}{
+					"type":        "integer",
+					"minimum":     1,
+					"description": "Downscale so the longer side is at most this many pixels",
+				},
+			},
+		},
+		Device:  DeviceOptional,
+		Safety:  SafetyReadOnly,
+		Handler: handleGetScreen,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:        "android_emulator_console",
+		Description: "Control an Android emulator through its console: set GPS location, send SMS, simulate incoming calls, set battery state, change network speed/latency, or rotate the screen",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"action": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"geo_fix", "sms_send", "gsm_call", "battery", "network", "rotate"},
+					"description": "Console action to perform",
+				},
+				"latitude": map[string]interface{}{
+					"type":        "number",
+					"minimum":     -90,
+					"maximum":     90,
+					"description": "Latitude in decimal degrees (geo_fix)",
+				},
+				"longitude": map[string]interface{}{
+					"type":        "number",
+					"minimum":     -180,
+					"maximum":     180,
+					"description": "Longitude in decimal degrees (geo_fix)",
+				},
+				"altitude": map[string]interface{}{
+					"type":        "number",
+					"description": "Altitude in meters (geo_fix, optional)",
+				},
+				"phone_number": map[string]interface{}{
+					"type":        "string",
+					"description": "Sender or caller phone number (sms_send, gsm_call)",
+				},
+				"message": map[string]interface{}{
+					"type":        "string",
+					"description": "SMS text (sms_send)",
+				},
+				"battery_level": map[string]interface{}{
+					"type":        "integer",
+					"minimum":     0,
+					"maximum":     100,
+					"description": "Battery charge level in percent (battery)",
+				},
+				"charging": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Whether the emulator is plugged into AC power and charging (battery)",
+				},
+				"network_speed": map[string]interface{}{
+					"type":        "string",
+					"description": "Network speed profile such as gsm, edge, umts, hsdpa, lte or full (network)",
+				},
+				"network_delay": map[string]interface{}{
+					"type":        "string",
+					"description": "Network latency profile such as gprs, edge, umts or none (network)",
+				},
+			},
+			"required": []string{"action"},
+		},
+		Device:  DeviceEmulator,
+		Safety:  SafetyInteractive,
+		Handler: handleEmulatorConsole,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:        "android_emulator_snapshot",
+		Description: "Save, load, list or delete emulator snapshots to reset an emulator to a known state in seconds",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"action": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"save", "load", "list", "delete"},
+					"description": "Snapshot operation to perform",
+				},
+				"name": map[string]interface{}{
+					"type":        "string",
+					"description": "Snapshot name (required for save, load and delete)",
+				},
+			},
+			"required": []string{"action"},
+		},
+		Device: DeviceEmulator,
+		Safety: SafetyDestructive,
+		CallSafety: func(arguments map[string]interface{}) ToolSafety {
+			if getStringArgument(arguments, "action") == "list" {
+				return SafetyReadOnly
+			}
+			return SafetyDestructive
+		},
+		Handler: handleEmulatorSnapshot,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:        "android_list_avds",
+		Description: "List the Android Virtual Devices (AVDs) available to start, with their target API, ABI and skin",
+		OutputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"avds": map[string]interface{}{
+					"type": "array",
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"name":         map[string]interface{}{"type": "string"},
+							"display_name": map[string]interface{}{"type": "string"},
+							"target":       map[string]interface{}{"type": "string"},
+							"api_level":    map[string]interface{}{"type": "string"},
+							"abi":          map[string]interface{}{"type": "string"},
+							"skin":         map[string]interface{}{"type": "string"},
+							"path":         map[string]interface{}{"type": "string"},
+						},
+						"required": []string{"name"},
+					},
+				},
+			},
+			"required": []string{"avds"},
+		},
+		Device:  DeviceNone,
+		Safety:  SafetyReadOnly,
+		Handler: handleListAVDs,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:        "android_start_emulator",
+		Description: "Start an Android emulator for an AVD and wait until it has finished booting",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"avd_name": map[string]interface{}{
+					"type":        "string",
+					"description": "Name of the AVD to start, as returned by android_list_avds",
+				},
+				"headless": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Run without a window, audio or boot animation",
+				},
+				"wipe_data": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Reset user data to the factory state before booting",
+				},
+				"cold_boot": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Perform a full boot instead of loading the quick-boot snapshot",
+				},
+				"snapshot": map[string]interface{}{
+					"type":        "string",
+					"description": "Name of a snapshot to boot from (ignored when cold_boot is set)",
+				},
+				"timeout_seconds": map[string]interface{}{
+					"type":        "integer",
+					"minimum":     1,
+					"description": "Maximum time to wait for sys.boot_completed (default 300)",
+				},
+			},
+			"required": []string{"avd_name"},
+		},
+		Device:   DeviceNone,
+		Safety:   SafetyInteractive,
+		Commands: startEmulatorCommands,
+		Handler:  handleStartEmulator,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:        "android_kill_emulator",
+		Description: "Shut down a running Android emulator",
+		Device:      DeviceRequired,
+		Safety:      SafetyDestructive,
+		Handler:     handleKillEmulator,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:        "android_shell",
+		Description: "Run a shell command on an Android device and return its stdout, stderr and exit code",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"command": map[string]interface{}{
+					"type":        "string",
+					"description": "Command line to run with `adb shell`, e.g. 'dumpsys activity top'",
+				},
+				"timeout_seconds": map[string]interface{}{
+					"type":        "integer",
+					"minimum":     1,
+					"description": "Kill the command if it runs longer than this (default 30, or the configured shell timeout)",
+				},
+			},
+			"required": []string{"command"},
+		},
+		OutputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"stdout":         map[string]interface{}{"type": "string"},
+				"stderr":         map[string]interface{}{"type": "string"},
+				"exit_code":      map[string]interface{}{"type": "integer"},
+				"truncated":      map[string]interface{}{"type": "boolean"},
+				"shell_protocol": map[string]interface{}{"type": "string", "enum": []string{"v2", "legacy"}},
+			},
+			"required": []string{"stdout", "stderr", "exit_code", "truncated", "shell_protocol"},
+		},
+		Device: DeviceOptional,
+		Safety: SafetyDestructive,
+		Commands: func(arguments map[string]interface{}) []string {
+			return []string{getStringArgument(arguments, "command")}
+		},
+		Handler: handleShell,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:        "android_adb_discover",
+		Description: "Discover devices advertising adb over mDNS on the local network, such as phones with Wireless debugging enabled",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"service_type": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"_adb-tls-connect._tcp", "_adb-tls-pairing._tcp", "_adb._tcp", "all"},
+					"description": "Service to look for: _adb-tls-connect._tcp (paired devices ready to connect, the default), _adb-tls-pairing._tcp (devices showing a pairing code), _adb._tcp (devices in tcpip mode) or all",
+				},
+				"adb_server": adbServerProperty(),
+			},
+		},
+		OutputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"services": map[string]interface{}{
+					"type": "array",
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"name":    map[string]interface{}{"type": "string"},
+							"type":    map[string]interface{}{"type": "string"},
+							"address": map[string]interface{}{"type": "string"},
+						},
+						"required": []string{"name", "type", "address"},
+					},
+				},
+			},
+			"required": []string{"services"},
+		},
+		Device:  DeviceNone,
+		Safety:  SafetyReadOnly,
+		Handler: handleADBDiscover,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:        "android_adb_pair",
+		Description: "Pair with a device for wireless debugging (Android 11+) using the pairing code and address shown under Wireless debugging > Pair device with pairing code",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"address": map[string]interface{}{
+					"type":        "string",
+					"description": "host:port shown next to the pairing code; the pairing port differs from the connect port",
+				},
+				"pairing_code": map[string]interface{}{
+					"type":        "string",
+					"description": "Six digit pairing code shown on the device",
+				},
+				"adb_server": adbServerProperty(),
+			},
+			"required": []string{"address", "pairing_code"},
+		},
+		Device:  DeviceNone,
+		Safety:  SafetyInteractive,
+		Handler: handleADBPair,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:        "android_adb_connect",
+		Description: "Connect to a device over Wi-Fi, after pairing or after android_adb_tcpip, so it appears in get_android_devices",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"address": map[string]interface{}{
+					"type":        "string",
+					"description": "host:port of the device, e.g. '192.168.1.5:37123' from android_adb_discover (port 5555 if omitted)",
+				},
+				"adb_server": adbServerProperty(),
+			},
+			"required": []string{"address"},
+		},
+		Device:  DeviceNone,
+		Safety:  SafetyInteractive,
+		Handler: handleADBConnect,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:        "android_adb_disconnect",
+		Description: "Disconnect a device connected over Wi-Fi, or every such device when no address is given",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"address": map[string]interface{}{
+					"type":        "string",
+					"description": "host:port of the device to disconnect, as listed in get_android_devices",
+				},
+				"adb_server": adbServerProperty(),
+			},
+		},
+		Device:  DeviceNone,
+		Safety:  SafetyInteractive,
+		Handler: handleADBDisconnect,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:        "android_adb_tcpip",
+		Description: "Restart adbd on a USB-attached device listening for adb over TCP, and return the address to pass to android_adb_connect",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"port": map[string]interface{}{
+					"type":        "integer",
+					"minimum":     1,
+					"maximum":     65535,
+					"description": "Port for adbd to listen on (default 5555)",
+				},
+			},
+		},
+		Device:  DeviceRequired,
+		Safety:  SafetyInteractive,
+		Handler: handleADBTCPIP,
+	})
+
+	registry.Register(ToolDefinition{
+		Name:         "android_adb_forward",
+		Description:  "Create, list or remove adb forward rules, which make a socket on the device reachable from a port or socket on the host running the device's adb server (this computer unless the server is remote)",
+		InputSchema:  portRuleInputSchema("local", "Host socket to listen on, e.g. 'tcp:9222' or 'tcp:0' to let adb pick a free port (create, remove)", "Device socket to connect to, e.g. 'tcp:8080' or 'localabstract:chrome_devtools_remote' (create)"),
+		OutputSchema: portRuleOutputSchema(),
+		Device:       DeviceOptional,
+		Safety:       SafetyInteractive,
+		CallSafety:   portRuleCallSafety,
+		Handler:      handlePortRules(false),
+	})
+
+	registry.Register(ToolDefinition{
+		Name:         "android_adb_reverse",
+		Description:  "Create, list or remove adb reverse rules, which let the device reach a port or socket on the host running the device's adb server (this computer unless the server is remote), e.g. a local development backend",
+		InputSchema:  portRuleInputSchema("remote", "Host socket to connect to, e.g. 'tcp:8080' (create)", "Device socket to listen on, e.g. 'tcp:8080' or 'tcp:0' to let adb pick a free port (create, remove)"),
+		OutputSchema: portRuleOutputSchema(),
+		Device:       DeviceOptional,
+		Safety:       SafetyInteractive,
+		CallSafety:   portRuleCallSafety,
+		Handler:      handlePortRules(true),
+	})
+
+	return registry
+}
+
+// portRuleInputSchema describes the arguments of android_adb_forward and android_adb_reverse; listener names the side
+// a rule listens on, which also identifies the rule to remove
+func portRuleInputSchema(listener string, localDescription string, remoteDescription string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"action": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"create", "list", "remove"},
+				"description": "Rule operation to perform; remove without " + listener + " removes every rule of the device",
+			},
+			"local": map[string]interface{}{
+				"type":        "string",
+				"description": localDescription,
+			},
+			"remote": map[string]interface{}{
+				"type":        "string",
+				"description": remoteDescription,
+			},
+			"no_rebind": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Fail instead of replacing an existing rule listening on the same socket (create)",
+			},
+		},
+		"required": []string{"action"},
+	}
+}
+
+// portRuleCallSafety lets read-only policies list rules
+func portRuleCallSafety(arguments map[string]interface{}) ToolSafety {
+	if getStringArgument(arguments, "action") == "list" {
+		return SafetyReadOnly
+	}
+	return SafetyInteractive
+}
+
+// portRuleOutputSchema reports the device's rules after the action, and the port adb picked for tcp:0
+func portRuleOutputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"rules": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"local":  map[string]interface{}{"type": "string"},
+						"remote": map[string]interface{}{"type": "string"},
+					},
+					"required": []string{"local", "remote"},
+				},
+			},
+			"allocated_port": map[string]interface{}{"type": "integer"},
+		},
+		"required": []string{"rules"},
+	}
+}
+
+// adbServerProperty is the schema of the optional server argument of tools that talk to an adb server rather than a device
+func adbServerProperty() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "string",
+		"description": "adb server to use, as host:port from the host field of get_android_devices (default: the first configured server)",
+	}
+}
+
+func handleGetDevices(call *ToolCall) (ToolsCallResult, error) {
+	devices, err := getDeviceList(call.Context())
+	if err != nil {
+		return ToolsCallResult{}, err
+	}
+
+	if devices == nil {
+		devices = []Device{}
+	}
+
+	var output interface{} = devices
+	if fields := getStringListArgument(call.Arguments, "fields"); len(fields) > 0 {
+		output = filterDeviceFields(devices, fields)
+	}
+
+	devicesJSON, _ := json.Marshal(output)
+	result := textResult(string(devicesJSON))
+	result.StructuredContent = map[string]interface{}{"devices": output}
+	return result, nil
+}
+
+func handleGetScreen(call *ToolCall) (ToolsCallResult, error) {
+	// Capture screenshot
+	base64Data, mimeType, err := captureScreenshot(call.Context(), call.Device, screenshotOptions(call.Arguments))
+	if err != nil {
+		return ToolsCallResult{}, err
+	}
+
+	return ToolsCallResult{
+		Content: []ContentItem{
+			{
+				Type:     "image",
+				Data:     base64Data,
+				MimeType: mimeType,
+			},
+		},
+		IsError: false,
+	}, nil
+}
+
+func handleEmulatorConsole(call *ToolCall) (ToolsCallResult, error) {
+	action := getStringArgument(call.Arguments, "action")
+	commands, err := buildEmulatorConsoleCommands(action, call.Arguments)
+	if err != nil {
+		return ToolsCallResult{}, invalidParams("%w", err)
+	}
+
+	output, err := runEmulatorConsoleCommands(call.Context(), call.Device, commands)
+	if err != nil {
+		return ToolsCallResult{}, err
+	}
+
+	text := fmt.Sprintf("Emulator %s: %s done", call.Device, action)
+	if output != "" {
+		text += "\n" + output
+	}
+	return textResult(text), nil
+}
+
+func handleEmulatorSnapshot(call *ToolCall) (ToolsCallResult, error) {
+	action := getStringArgument(call.Arguments, "action")
+	command, err := buildEmulatorSnapshotCommand(action, getStringArgument(call.Arguments, "name"))
+	if err != nil {
+		return ToolsCallResult{}, invalidParams("%w", err)
+	}
+
+	output, err := runEmulatorConsoleCommands(call.Context(), call.Device, []string{command})
+	if err != nil {
+		return ToolsCallResult{}, err
+	}
+
+	if action == "list" {
+		return textResult(output), nil
+	}
+	return textResult(fmt.Sprintf("Emulator %s: snapshot %s done", call.Device, action)), nil
+}
+
+func handleListAVDs(call *ToolCall) (ToolsCallResult, error) {
+	avds, err := getAVDList()
+	if err != nil {
+		return ToolsCallResult{}, err
+	}
+
+	if avds == nil {
+		avds = []AVD{}
+	}
+
+	avdsJSON, _ := json.Marshal(avds)
+	result := textResult(string(avdsJSON))
+	result.StructuredContent = map[string]interface{}{"avds": avds}
+	return result, nil
+}
+
+func handleStartEmulator(call *ToolCall) (ToolsCallResult, error) {
+	avdName := getStringArgument(call.Arguments, "avd_name")
+
+	var options EmulatorStartOptions
+	options.Headless, _ = getBoolArgument(call.Arguments, "headless")
+	options.WipeData, _ = getBoolArgument(call.Arguments, "wipe_data")
+	options.ColdBoot, _ = getBoolArgument(call.Arguments, "cold_boot")
+	options.Snapshot = getStringArgument(call.Arguments, "snapshot")
+	if timeout, ok := getNumberArgument(call.Arguments, "timeout_seconds"); ok {
+		options.BootTimeout = time.Duration(timeout * float64(time.Second))
+	}
+	options.Progress = func(percent float64, message string) {
+		call.ReportProgress(percent, 100, message)
+	}
+
+	deviceName, err := startEmulator(call.Context(), avdName, options)
+	if err != nil {
+		return ToolsCallResult{}, err
+	}
+	return textResult(fmt.Sprintf("Emulator %s booted as %s", avdName, deviceName)), nil
+}
+
+// startEmulatorCommands reports the emulator command line when it wipes user data, so the policy can block it
+func startEmulatorCommands(arguments map[string]interface{}) []string {
+	if wipeData, _ := getBoolArgument(arguments, "wipe_data"); wipeData {
+		return []string{"emulator -avd " + getStringArgument(arguments, "avd_name") + " -wipe-data"}
+	}
+	return nil
+}
+
+func handleKillEmulator(call *ToolCall) (ToolsCallResult, error) {
+	if err := killEmulator(call.Context(), call.Device); err != nil {
+		return ToolsCallResult{}, err
+	}
+	return textResult(fmt.Sprintf("Emulator %s is shutting down", call.Device)), nil
+}
+
+func handleShell(call *ToolCall) (ToolsCallResult, error) {
+	command := getStringArgument(call.Arguments, "command")
+	if strings.TrimSpace(command) == "" {
+		return ToolsCallResult{}, invalidParams("command must not be empty")
+	}
+	if err := currentConfig().Shell.checkCommand(command); err != nil {
+		return ToolsCallResult{}, err
+	}
+
+	timeout := currentConfig().shellTimeout()
+	if seconds, ok := getNumberArgument(call.Arguments, "timeout_seconds"); ok {
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+
+	shellResult, err := runShellCommand(call.Context(), call.Device, command, timeout)
+	if err != nil {
+		return ToolsCallResult{}, err
+	}
+
+	resultJSON, _ := json.Marshal(shellResult)
+	result := textResult(string(resultJSON))
+	result.StructuredContent = shellResult
+	return result, nil
+}
+
+func handleADBDiscover(call *ToolCall) (ToolsCallResult, error) {
+	server, err := findADBServer(getStringArgument(call.Arguments, "adb_server"))
+	if err != nil {
+		return ToolsCallResult{}, invalidParams("%w", err)
+	}
+
+	serviceType := getStringArgument(call.Arguments, "service_type")
+	switch serviceType {
+	case "":
+		serviceType = mdnsConnectService
+	case "all":
+		serviceType = ""
+	}
+
+	services, err := discoverMDNSServices(call.Context(), server, serviceType)
+	if err != nil {
+		return ToolsCallResult{}, err
+	}
+
+	servicesJSON, _ := json.Marshal(services)
+	result := textResult(string(servicesJSON))
+	result.StructuredContent = map[string]interface{}{"services": services}
+	return result, nil
+}
+
+func handleADBPair(call *ToolCall) (ToolsCallResult, error) {
+	server, err := findADBServer(getStringArgument(call.Arguments, "adb_server"))
+	if err != nil {
+		return ToolsCallResult{}, invalidParams("%w", err)
+	}
+	address, err := parseDeviceAddress(getStringArgument(call.Arguments, "address"), 0)
+	if err != nil {
+		return ToolsCallResult{}, invalidParams("%w", err)
+	}
+	pairingCode := strings.TrimSpace(getStringArgument(call.Arguments, "pairing_code"))
+	if _, err := strconv.Atoi(pairingCode); err != nil || len(pairingCode) != 6 {
+		return ToolsCallResult{}, invalidParams("pairing_code must be the six digits shown on the device")
+	}
+	if err := currentConfig().Policy.checkDevice(address); err != nil {
+		return ToolsCallResult{}, err
+	}
+
+	output, err := adbPair(call.Context(), server, address, pairingCode)
+	if err != nil {
+		return ToolsCallResult{}, err
+	}
+	return textResult(output + "\nConnect with android_adb_connect to the address shown under Wireless debugging, or find it with android_adb_discover"), nil
+}
+
+func handleADBConnect(call *ToolCall) (ToolsCallResult, error) {
+	server, err := findADBServer(getStringArgument(call.Arguments, "adb_server"))
+	if err != nil {
+		return ToolsCallResult{}, invalidParams("%w", err)
+	}
+	address, err := parseDeviceAddress(getStringArgument(call.Arguments, "address"), defaultTCPIPPort)
+	if err != nil {
+		return ToolsCallResult{}, invalidParams("%w", err)
+	}
+	if err := currentConfig().Policy.checkDevice(address); err != nil {
+		return ToolsCallResult{}, err
+	}
+
+	output, err := adbConnect(call.Context(), server, address)
+	if err != nil {
+		return ToolsCallResult{}, err
+	}
+	return textResult(output), nil
+}
+
+func handleADBDisconnect(call *ToolCall) (ToolsCallResult, error) {
+	server, err := findADBServer(getStringArgument(call.Arguments, "adb_server"))
+	if err != nil {
+		return ToolsCallResult{}, invalidParams("%w", err)
+	}
+	var address string
+	if argument := getStringArgument(call.Arguments, "address"); argument != "" {
+		if address, err = parseDeviceAddress(argument, defaultTCPIPPort); err != nil {
+			return ToolsCallResult{}, invalidParams("%w", err)
+		}
+	}
+	if err := checkDisconnectPolicy(call.Context(), server, address); err != nil {
+		return ToolsCallResult{}, err
+	}
+
+	output, err := adbDisconnect(call.Context(), server, address)
+	if err != nil {
+		return ToolsCallResult{}, err
+	}
+	if output == "" {
+		output = "Disconnected everything"
+	}
+	return textResult(output), nil
+}
+
+func handleADBTCPIP(call *ToolCall) (ToolsCallResult, error) {
+	port := defaultTCPIPPort
+	if value, ok := getNumberArgument(call.Arguments, "port"); ok {
+		port = int(value)
+	}
+
+	address, err := adbTCPIP(call.Context(), call.Device, port)
+	if err != nil {
+		return ToolsCallResult{}, err
+	}
+	if address == "" {
+		return textResult(fmt.Sprintf("Device %s is listening for adb on port %d; connect with android_adb_connect to its Wi-Fi address", call.Device, port)), nil
+	}
+	return textResult(fmt.Sprintf("Device %s is listening for adb on port %d; connect with android_adb_connect to %s", call.Device, port, address)), nil
+}
+
+// handlePortRules handles android_adb_forward, or android_adb_reverse when reverse is set
+func handlePortRules(reverse bool) func(call *ToolCall) (ToolsCallResult, error) {
+	return func(call *ToolCall) (ToolsCallResult, error) {
+		action := getStringArgument(call.Arguments, "action")
+		rule := PortRule{
+			Local:  getStringArgument(call.Arguments, "local"),
+			Remote: getStringArgument(call.Arguments, "remote"),
+		}
+		// Rules are identified by the socket they listen on: local for forward, remote for reverse
+		listenerName, listener := "local", rule.Local
+		if reverse {
+			listenerName, listener = "remote", rule.Remote
+		}
+
+		output := map[string]interface{}{}
+		var text string
+		switch action {
+		case "create":
+			if err := validateSocketSpec("local", rule.Local, !reverse); err != nil {
+				return ToolsCallResult{}, invalidParams("%w", err)
+			}
+			if err := validateSocketSpec("remote", rule.Remote, reverse); err != nil {
+				return ToolsCallResult{}, invalidParams("%w", err)
+			}
+			noRebind, _ := getBoolArgument(call.Arguments, "no_rebind")
+			created, err := createPortRule(call.Context(), call.Device, reverse, rule, noRebind)
+			if err != nil {
+				return ToolsCallResult{}, err
+			}
+			text = fmt.Sprintf("Device %s: %s %s to %s", call.Device, portRuleCommand(reverse), created.Local, created.Remote)
+			if listener == "tcp:0" {
+				allocated := created.Local
+				if reverse {
+					allocated = created.Remote
+				}
+				port, _ := strconv.Atoi(strings.TrimPrefix(allocated, "tcp:"))
+				output["allocated_port"] = port
+				text += fmt.Sprintf(" (allocated port %d)", port)
+			}
+		case "remove":
+			if listener != "" {
+				if err := validateSocketSpec(listenerName, listener, false); err != nil {
+					return ToolsCallResult{}, invalidParams("%w", err)
+				}
+			}
+			if err := removePortRule(call.Context(), call.Device, reverse, listener); err != nil {
+				return ToolsCallResult{}, err
+			}
+			if listener == "" {
+				listener = "all"
+			}
+			text = fmt.Sprintf("Device %s: removed %s rules: %s", call.Device, portRuleCommand(reverse), listener)
+		case "list":
+		default:
+			return ToolsCallResult{}, invalidParams("unknown action %q", action)
+		}
+
+		rules, err := listPortRules(call.Context(), call.Device, reverse)
+		if err != nil {
+			return ToolsCallResult{}, err
+		}
+		output["rules"] = rules
+
+		rulesJSON, _ := json.Marshal(rules)
+		if text == "" {
+			text = string(rulesJSON)
+		} else {
+			text += "\nRules: " + string(rulesJSON)
+		}
+		result := textResult(text)
+		result.StructuredContent = output
+		return result, nil
+	}
+}
+
+func getStringArgument(arguments map[string]interface{}, name string) string {
+	if value, exists := arguments[name]; exists {
+		if valueStr, ok := value.(string); ok {
+			return valueStr
+		}
+	}
+	return ""
+}
+
+func getStringListArgument(arguments map[string]interface{}, name string) []string {
+	var values []string
+	if list, ok := arguments[name].([]interface{}); ok {
+		for _, item := range list {
+			if itemStr, ok := item.(string); ok {
+				values = append(values, itemStr)
+			}
+		}
+	}
+	return values
+}
+
+func getNumberArgument(arguments map[string]interface{}, name string) (float64, bool) {
+	if value, exists := arguments[name]; exists {
+		switch number := value.(type) {
+		case float64:
+			return number, true
+		case int:
+			return float64(number), true
+		}
+	}
+	return 0, false
+}
+
+func getBoolArgument(arguments map[string]interface{}, name string) (bool, bool) {
+	if value, exists := arguments[name]; exists {
+		if valueBool, ok := value.(bool); ok {
+			return valueBool, true
+		}
+	}
+	return false, false
+}
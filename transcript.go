@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// replayEntryEnvVar tells a re-executed server binary to act as a recorded command: "<index>:<fixture path>"
+const replayEntryEnvVar = "MCP_ANDROID_DEVICES_REPLAY_ENTRY"
+
+// TranscriptEntry is one line of a transcript fixture: a finished command and what it printed.
+// Output that is not valid UTF-8, such as a PNG screenshot, is stored base64-encoded instead.
+type TranscriptEntry struct {
+	Argv         []string `json:"argv"`
+	Stdout       string   `json:"stdout,omitempty"`
+	StdoutBase64 []byte   `json:"stdout_base64,omitempty"`
+	Stderr       string   `json:"stderr,omitempty"`
+	StderrBase64 []byte   `json:"stderr_base64,omitempty"`
+	ExitCode     int      `json:"exit_code"`
+}
+
+// transcriptRecorder appends every command built by execCommand and finished through runWithDeadline to a fixture file
+type transcriptRecorder struct {
+	mutex       sync.Mutex
+	file        *os.File
+	commands    sync.Map // *exec.Cmd to the argv it was built from
+	execCommand func(string, ...string) *exec.Cmd
+}
+
+// transcriptReplayer answers commands from a fixture instead of running them
+type transcriptReplayer struct {
+	mutex   sync.Mutex
+	path    string
+	entries []TranscriptEntry
+	used    []bool
+}
+
+var activeRecorder atomic.Pointer[transcriptRecorder]
+
+// startRecording truncates path and records every command from now on into it
+func startRecording(path string) error {
+	if err := currentConfig().checkWritePath(path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create transcript: %w", err)
+	}
+
+	// Remember the argv each command was built from, since wrappers such as test helpers change cmd.Args
+	recorder := &transcriptRecorder{file: file, execCommand: execCommand}
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		cmd := recorder.execCommand(command, args...)
+		recorder.commands.Store(cmd, transcriptArgv(append([]string{command}, args...)))
+		return cmd
+	}
+	activeRecorder.Store(recorder)
+	return nil
+}
+
+// stopRecording closes the transcript, if one is being recorded
+func stopRecording() error {
+	recorder := activeRecorder.Swap(nil)
+	if recorder == nil {
+		return nil
+	}
+	execCommand = recorder.execCommand
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return recorder.file.Close()
+}
+
+// capture tees the output of cmd, which must not be started yet; the returned function records it once cmd has finished
+func (r *transcriptRecorder) capture(cmd *exec.Cmd) func() {
+	argv, ok := r.commands.LoadAndDelete(cmd)
+	if !ok {
+		return func() {}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = teeWriter(cmd.Stdout, &stdout)
+	cmd.Stderr = teeWriter(cmd.Stderr, &stderr)
+
+	return func() {
+		// Commands that never started have nothing to replay
+		if cmd.ProcessState == nil {
+			return
+		}
+
+		// Fixtures get committed, so secrets such as the pairing code are redacted like in the audit log
+		entry := TranscriptEntry{Argv: redactArgv(argv.([]string)), ExitCode: cmd.ProcessState.ExitCode()}
+		entry.setOutput(stdout.Bytes(), stderr.Bytes())
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if _, err := r.file.Write(append(line, '\n')); err != nil {
+			logMessage("error", "transcript", "Failed to record %s: %v", strings.Join(cmd.Args, " "), err)
+		}
+	}
+}
+
+// teeWriter also copies what a command writes to w into copy; a nil w discards, as exec.Cmd does
+func teeWriter(w io.Writer, copy io.Writer) io.Writer {
+	if w == nil {
+		return copy
+	}
+	return io.MultiWriter(w, copy)
+}
+
+func (e *TranscriptEntry) setOutput(stdout []byte, stderr []byte) {
+	if utf8.Valid(stdout) {
+		e.Stdout = string(stdout)
+	} else {
+		e.StdoutBase64 = stdout
+	}
+	if utf8.Valid(stderr) {
+		e.Stderr = string(stderr)
+	} else {
+		e.StderrBase64 = stderr
+	}
+}
+
+func (e *TranscriptEntry) output() ([]byte, []byte) {
+	stdout, stderr := []byte(e.Stdout), []byte(e.Stderr)
+	if e.StdoutBase64 != nil {
+		stdout = e.StdoutBase64
+	}
+	if e.StderrBase64 != nil {
+		stderr = e.StderrBase64
+	}
+	return stdout, stderr
+}
+
+// transcriptArgv drops the directory and extension of the program, so a transcript
+// recorded with one SDK location or operating system replays on another
+func transcriptArgv(args []string) []string {
+	argv := append([]string(nil), args...)
+	if len(argv) > 0 {
+		program := filepath.Base(argv[0])
+		argv[0] = strings.TrimSuffix(program, filepath.Ext(program))
+	}
+	return argv
+}
+
+// loadTranscript reads a fixture written by a recorder
+func loadTranscript(path string) ([]TranscriptEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transcript: %w", err)
+	}
+	defer file.Close()
+
+	var entries []TranscriptEntry
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var entry TranscriptEntry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+			}
+			if len(entry.Argv) == 0 {
+				return nil, fmt.Errorf("%s:%d: argv must not be empty", path, lineNumber)
+			}
+			entries = append(entries, entry)
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read transcript: %w", err)
+		}
+	}
+}
+
+// startReplay makes execCommand answer from the fixture at path instead of running anything
+func startReplay(path string) error {
+	entries, err := loadTranscript(path)
+	if err != nil {
+		return err
+	}
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the server binary for replay: %w", err)
+	}
+
+	replayer := &transcriptReplayer{path: absolutePath, entries: entries, used: make([]bool, len(entries))}
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		return replayer.command(executable, command, args...)
+	}
+	lookPath = func(file string) (string, error) { return file, nil }
+	return nil
+}
+
+// command returns a process that prints the recorded output of the matching entry and exits with its code.
+// Re-running the server binary keeps exit codes, stderr and process state real for callers, audit and traces.
+func (r *transcriptReplayer) command(executable string, command string, args ...string) *exec.Cmd {
+	cmd := exec.Command(executable)
+	cmd.Args = append([]string{command}, args...)
+
+	index := r.next(redactArgv(transcriptArgv(cmd.Args)))
+	if index < 0 {
+		cmd.Err = fmt.Errorf("no recorded response for %q in %s", strings.Join(cmd.Args, " "), r.path)
+		return cmd
+	}
+	cmd.Env = append(os.Environ(), replayEntryEnvVar+"="+strconv.Itoa(index)+":"+r.path)
+	return cmd
+}
+
+// next picks the first unused entry for argv, in recording order. Once all are used the last one keeps
+// answering, so polling loops such as waiting for boot settle on the final recorded state.
+func (r *transcriptReplayer) next(argv []string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	last := -1
+	for i, entry := range r.entries {
+		if !reflect.DeepEqual(entry.Argv, argv) {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return i
+		}
+		last = i
+	}
+	return last
+}
+
+// replayedProcess plays back one recorded command when the binary was started by a replayer,
+// returning the exit status and true; otherwise it returns false
+func replayedProcess() (int, bool) {
+	value := os.Getenv(replayEntryEnvVar)
+	if value == "" {
+		return 0, false
+	}
+
+	indexText, path, _ := strings.Cut(value, ":")
+	index, err := strconv.Atoi(indexText)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid %s: %s\n", replayEntryEnvVar, value)
+		return 1, true
+	}
+	entries, err := loadTranscript(path)
+	if err != nil || index < 0 || index >= len(entries) {
+		fmt.Fprintf(os.Stderr, "transcript entry %d of %s is unavailable: %v\n", index, path, err)
+		return 1, true
+	}
+
+	stdout, stderr := entries[index].output()
+	os.Stdout.Write(stdout)
+	os.Stderr.Write(stderr)
+	return entries[index].ExitCode, true
+}
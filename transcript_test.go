@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTranscript(t *testing.T) {
+	originalExecCommand := execCommand
+	execCommand = helperCommand
+	originalLookPath := lookPath
+	lookPath = func(file string) (string, error) { return file, nil }
+	defer func() {
+		stopRecording()
+		execCommand = originalExecCommand
+		lookPath = originalLookPath
+	}()
+
+	type session struct {
+		shell      ShellResult
+		screenshot string
+		devices    []Device
+		pairing    string
+	}
+	runSession := func(t *testing.T) session {
+		var s session
+		var err error
+		if s.shell, err = runShellCommand(context.Background(), "emulator-5554", "fail", 5*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if s.screenshot, _, err = captureScreenshot(context.Background(), "emulator-5554", ScreenshotOptions{Format: "png"}); err != nil {
+			t.Fatal(err)
+		}
+		if s.devices, err = getDeviceList(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if s.pairing, err = adbPair(context.Background(), adbServer{}, "192.168.1.5:40001", "123456"); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	if err := startRecording(path); err != nil {
+		t.Fatal(err)
+	}
+	recorded := runSession(t)
+	if err := stopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := loadTranscript(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sawShell, sawScreenshot, sawPair bool
+	for _, entry := range entries {
+		if entry.Argv[0] != "adb" {
+			t.Errorf("expected the program as passed to execCommand, got %q", entry.Argv)
+		}
+		switch strings.Join(entry.Argv[1:], " ") {
+		case "-s emulator-5554 shell fail":
+			sawShell = entry.Stdout == "out\n" && entry.Stderr == "err\n" && entry.ExitCode == 3
+		case "-s emulator-5554 exec-out screencap -p":
+			sawScreenshot = entry.Stdout == "" && len(entry.StdoutBase64) > 0
+		case "pair 192.168.1.5:40001 [REDACTED]":
+			sawPair = true
+		}
+	}
+	if !sawShell || !sawScreenshot {
+		t.Errorf("expected the shell command and the binary screenshot to be recorded, got %+v", entries)
+	}
+	if !sawPair {
+		t.Errorf("expected the pairing code to be redacted, got %+v", entries)
+	}
+
+	if err := startReplay(path); err != nil {
+		t.Fatal(err)
+	}
+	if replayed := runSession(t); !reflect.DeepEqual(replayed, recorded) {
+		t.Errorf("replay differs from the recording:\nrecorded %+v\nreplayed %+v", recorded, replayed)
+	}
+
+	if _, err := runShellCommand(context.Background(), "emulator-5554", "reboot", 5*time.Second); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("expected an unrecorded command to fail, got %v", err)
+	}
+}
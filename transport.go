@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+var sendBatchResponse = func(responses []JSONRPCResponse) {
+	responseBytes, _ := json.Marshal(responses)
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	fmt.Println(string(responseBytes))
+}
+
+// serve reads newline-delimited JSON-RPC messages until input is closed.
+// Lines may be of any length, and a malformed line is answered with an error without stopping the server.
+func serve(input io.Reader) error {
+	reader := bufio.NewReader(input)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			handleMessage(line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading from stdin: %w", err)
+		}
+	}
+}
+
+// handleMessage dispatches one line, which holds either a single message or a batch
+func handleMessage(line []byte) {
+	line = bytes.TrimSpace(line)
+	if line[0] == '[' {
+		handleBatch(line)
+		return
+	}
+	handleSingleMessage(line)
+}
+
+// handleBatch runs each message of a batch in order and answers with one array holding the responses;
+// a batch of notifications gets no answer
+func handleBatch(line []byte) {
+	var messages []json.RawMessage
+	if err := json.Unmarshal(line, &messages); err != nil {
+		sendError(nil, -32700, "Parse error", nil)
+		return
+	}
+	if len(messages) == 0 {
+		sendError(nil, -32600, "Invalid Request", map[string]interface{}{
+			"error": "empty batch",
+		})
+		return
+	}
+
+	// Requests are handled one at a time, so responses can be collected by swapping the sender
+	var responses []JSONRPCResponse
+	originalSendResponse := sendResponse
+	sendResponse = func(response JSONRPCResponse) {
+		responses = append(responses, response)
+	}
+	defer func() { sendResponse = originalSendResponse }()
+
+	for _, message := range messages {
+		handleSingleMessage(message)
+	}
+
+	if len(responses) > 0 {
+		sendBatchResponse(responses)
+	}
+}
+
+// handleSingleMessage validates one JSON-RPC message and handles it as a request or notification
+func handleSingleMessage(message []byte) {
+	if !json.Valid(message) {
+		sendError(nil, -32700, "Parse error", nil)
+		return
+	}
+
+	var request JSONRPCRequest
+	var envelope struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(message, &request); err != nil || json.Unmarshal(message, &envelope) != nil {
+		sendError(nil, -32600, "Invalid Request", nil)
+		return
+	}
+
+	// Messages without an id are notifications and must never be answered
+	if envelope.ID == nil {
+		handleNotification(request)
+		return
+	}
+	if request.Method == "" {
+		sendError(request.ID, -32600, "Invalid Request", map[string]interface{}{
+			"error": "method is required",
+		})
+		return
+	}
+
+	// A handler bug should fail its own request, not the whole session
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logMessage("error", "server", "Panic while handling %s: %v", request.Method, recovered)
+			sendError(request.ID, -32603, "Internal error", map[string]interface{}{
+				"error": fmt.Sprint(recovered),
+			})
+		}
+	}()
+	handleRequest(request)
+}
+
+func handleNotification(notification JSONRPCRequest) {
+	switch notification.Method {
+	case "notifications/initialized", "notifications/cancelled":
+	default:
+		logMessage("debug", "server", "Ignoring notification %s", notification.Method)
+	}
+}
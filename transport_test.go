@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	var responses []JSONRPCResponse
+	var batches [][]JSONRPCResponse
+	originalSendResponse := sendResponse
+	sendResponse = func(resp JSONRPCResponse) { responses = append(responses, resp) }
+	originalSendBatchResponse := sendBatchResponse
+	sendBatchResponse = func(resps []JSONRPCResponse) { batches = append(batches, resps) }
+	originalProtocolVersion := protocolVersion
+	originalClientInfo := clientInfo
+	defer func() {
+		clientInfo = originalClientInfo
+		sendResponse = originalSendResponse
+		sendBatchResponse = originalSendBatchResponse
+		protocolVersion = originalProtocolVersion
+	}()
+
+	// A message well over bufio.Scanner's 64 KiB default token size
+	largeName := strings.Repeat("x", 256*1024)
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","clientInfo":{"name":"` + largeName + `","version":"1"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":`,
+		`"just a string"`,
+		`[{"jsonrpc":"2.0","id":3,"method":"prompts/list"},{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":4,"method":"missing"}]`,
+		`[{"jsonrpc":"2.0","method":"notifications/initialized"}]`,
+		`[]`,
+		`{"jsonrpc":"2.0","id":5,"method":"prompts/list"}`,
+	}, "\n")
+
+	if err := serve(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	wantCodes := []int{0, -32700, -32600, -32600, 0}
+	if len(responses) != len(wantCodes) {
+		t.Fatalf("expected %d responses, got %+v", len(wantCodes), responses)
+	}
+	for i, want := range wantCodes {
+		got := 0
+		if responses[i].Error != nil {
+			got = responses[i].Error.Code
+		}
+		if got != want {
+			t.Errorf("response %d: expected code %d, got %+v", i, want, responses[i])
+		}
+	}
+	if clientInfo.Name != largeName {
+		t.Errorf("expected the large initialize request to be read whole")
+	}
+	if responses[4].ID != float64(5) {
+		t.Errorf("expected the server to keep serving after bad lines, got %+v", responses[4])
+	}
+
+	if len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("expected one batch with two responses, got %+v", batches)
+	}
+	if batches[0][0].ID != float64(3) || batches[0][1].Error == nil || batches[0][1].Error.Code != -32601 {
+		t.Errorf("unexpected batch responses: %+v", batches[0])
+	}
+}
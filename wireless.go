@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultTCPIPPort is the port adbd listens on after `adb tcpip` and the port `adb connect` assumes
+const defaultTCPIPPort = 5555
+
+// mdnsConnectService is advertised by devices with Wireless debugging enabled, ready for android_adb_connect once paired
+const mdnsConnectService = "_adb-tls-connect._tcp"
+
+// wlanAddressPattern finds the IPv4 address in `ip -f inet addr show wlan0`
+var wlanAddressPattern = regexp.MustCompile(`inet (\d+\.\d+\.\d+\.\d+)/`)
+
+// MDNSService is an adb service advertised on the local network, as listed by `adb mdns services`
+type MDNSService struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+// findADBServer returns the configured server named by address, in the host:port form of the device host field;
+// an empty address selects the first configured server
+func findADBServer(address string) (adbServer, error) {
+	servers := currentConfig().adbServers()
+	if address == "" {
+		return servers[0], nil
+	}
+	for _, server := range servers {
+		if server.String() == address {
+			return server, nil
+		}
+	}
+	return adbServer{}, fmt.Errorf("adb server %q is not configured", address)
+}
+
+// parseDeviceAddress checks a host:port device address; without a port defaultPort is used, or an error if it is 0
+func parseDeviceAddress(address string, defaultPort int) (string, error) {
+	address = strings.TrimSpace(address)
+	if address == "" || strings.HasPrefix(address, "-") {
+		return "", fmt.Errorf("invalid device address %q", address)
+	}
+
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		if defaultPort == 0 {
+			return "", fmt.Errorf("device address %q must be host:port", address)
+		}
+		host, portText = address, strconv.Itoa(defaultPort)
+	}
+	if port, err := strconv.Atoi(portText); err != nil || port < 1 || port > 65535 || host == "" {
+		return "", fmt.Errorf("invalid device address %q", address)
+	}
+	return net.JoinHostPort(host, portText), nil
+}
+
+// adbPair pairs with a device showing "Pair device with pairing code" under Wireless debugging (Android 11+)
+func adbPair(ctx context.Context, server adbServer, address string, pairingCode string) (string, error) {
+	cmd := adbServerCommand(ctx, server, "pair", address, pairingCode)
+	output, err := runWithTimeout(cmd, commandTimeout)
+	text := strings.TrimSpace(string(output))
+	// adb pair exits 0 on some versions even when pairing failed, so trust the output
+	if err != nil || !strings.Contains(text, "Successfully paired") {
+		if err != nil && text == "" {
+			text = err.Error()
+		}
+		return "", fmt.Errorf("failed to pair with %s: %s", address, text)
+	}
+	return text, nil
+}
+
+// adbConnect attaches a device listening for adb over TCP; reconnecting to an attached device is not an error
+func adbConnect(ctx context.Context, server adbServer, address string) (string, error) {
+	cmd := adbServerCommand(ctx, server, "connect", address)
+	output, err := runWithTimeout(cmd, commandTimeout)
+	text := strings.TrimSpace(string(output))
+	if err != nil || !strings.Contains(text, "connected to") {
+		if err != nil && text == "" {
+			text = err.Error()
+		}
+		return "", fmt.Errorf("failed to connect to %s: %s", address, text)
+	}
+	return text, nil
+}
+
+// adbDisconnect detaches a TCP device, or every TCP device when address is empty
+func adbDisconnect(ctx context.Context, server adbServer, address string) (string, error) {
+	args := []string{"disconnect"}
+	if address != "" {
+		args = append(args, address)
+	}
+	cmd := adbServerCommand(ctx, server, args...)
+	output, err := runWithTimeout(cmd, commandTimeout)
+	text := strings.TrimSpace(string(output))
+	if err != nil || strings.HasPrefix(text, "error:") {
+		if err != nil && text == "" {
+			text = err.Error()
+		}
+		return "", fmt.Errorf("failed to disconnect %s: %s", address, strings.TrimPrefix(text, "error: "))
+	}
+	return text, nil
+}
+
+// checkDisconnectPolicy applies the device policy to the device at address, or to every TCP device of the server
+// when address is empty, so disconnecting everything cannot drop a device the policy protects
+func checkDisconnectPolicy(ctx context.Context, server adbServer, address string) error {
+	policy := currentConfig().Policy
+	if address != "" {
+		return policy.checkDevice(address)
+	}
+	if len(policy.AllowedDevices) == 0 && len(policy.DeniedDevices) == 0 {
+		return nil
+	}
+
+	devices, err := listServerDevices(ctx, server)
+	if err != nil {
+		return err
+	}
+	for _, device := range devices {
+		if device.ConnectionType != "tcp" {
+			continue
+		}
+		if err := policy.checkDevice(device.Device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adbTCPIP restarts adbd on the device listening on port and returns the device's Wi-Fi address, if it has one
+func adbTCPIP(ctx context.Context, deviceName string, port int) (string, error) {
+	// Read the address first, the device drops off USB while adbd restarts
+	var address string
+	if result, err := runShellCommand(ctx, deviceName, "ip -f inet addr show wlan0", commandTimeout); err == nil {
+		if match := wlanAddressPattern.FindStringSubmatch(result.Stdout); match != nil {
+			address = net.JoinHostPort(match[1], strconv.Itoa(port))
+		}
+	}
+
+	cmd := adbCommand(ctx, "-s", deviceName, "tcpip", strconv.Itoa(port))
+	output, err := runWithTimeout(cmd, commandTimeout)
+	if err != nil || strings.HasPrefix(strings.TrimSpace(string(output)), "error:") {
+		return "", fmt.Errorf("failed to restart adbd in TCP mode on device %s: %v, output: %s", deviceName, err, strings.TrimSpace(string(output)))
+	}
+	return address, nil
+}
+
+// discoverMDNSServices lists the adb services of serviceType advertised over mDNS, or all of them for an empty type
+func discoverMDNSServices(ctx context.Context, server adbServer, serviceType string) ([]MDNSService, error) {
+	cmd := adbServerCommand(ctx, server, "mdns", "services")
+	output, err := outputWithTimeout(cmd, commandTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mdns services: %w%s", err, commandStderr(err))
+	}
+
+	services := []MDNSService{}
+	for _, service := range parseMDNSServices(string(output)) {
+		if serviceType == "" || service.Type == serviceType {
+			services = append(services, service)
+		}
+	}
+	return services, nil
+}
+
+// parseMDNSServices parses `adb mdns services`, whose lines are "<instance>\t<type>\t<host:port>" after a header;
+// older adb versions end the type with a dot
+func parseMDNSServices(output string) []MDNSService {
+	services := []MDNSService{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || !strings.HasPrefix(fields[1], "_adb") {
+			continue
+		}
+		services = append(services, MDNSService{
+			Name:    fields[0],
+			Type:    strings.TrimSuffix(fields[1], "."),
+			Address: fields[2],
+		})
+	}
+	return services
+}
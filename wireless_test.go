@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMDNSServices(t *testing.T) {
+	output := "List of discovered mdns services\n" +
+		"adb-R5CT1234-AbCdEf\t_adb-tls-connect._tcp\t192.168.1.5:37123\n" +
+		"adb-R5CT1234-AbCdEf\t_adb-tls-pairing._tcp.\t192.168.1.5:40001\n" +
+		"Pixel-7\t_adb._tcp\t192.168.1.6:5555\n"
+
+	want := []MDNSService{
+		{Name: "adb-R5CT1234-AbCdEf", Type: "_adb-tls-connect._tcp", Address: "192.168.1.5:37123"},
+		{Name: "adb-R5CT1234-AbCdEf", Type: "_adb-tls-pairing._tcp", Address: "192.168.1.5:40001"},
+		{Name: "Pixel-7", Type: "_adb._tcp", Address: "192.168.1.6:5555"},
+	}
+	if services := parseMDNSServices(output); !reflect.DeepEqual(services, want) {
+		t.Errorf("expected %+v, got %+v", want, services)
+	}
+	if services := parseMDNSServices("List of discovered mdns services\n"); len(services) != 0 {
+		t.Errorf("expected no services, got %+v", services)
+	}
+}
+
+func TestParseDeviceAddress(t *testing.T) {
+	tests := []struct {
+		address     string
+		defaultPort int
+		want        string
+		wantErr     string
+	}{
+		{"192.168.1.5:37123", 0, "192.168.1.5:37123", ""},
+		{"192.168.1.5", defaultTCPIPPort, "192.168.1.5:5555", ""},
+		{"192.168.1.5", 0, "", "must be host:port"},
+		{"192.168.1.5:70000", 0, "", "invalid device address"},
+		{"--help", defaultTCPIPPort, "", "invalid device address"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			address, err := parseDeviceAddress(test.address, test.defaultPort)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil || address != test.want {
+				t.Errorf("expected %s, got %s (%v)", test.want, address, err)
+			}
+		})
+	}
+}
+
+func TestWirelessDebugging(t *testing.T) {
+	useHelperDevices(t)
+	server := adbServer{}
+
+	if output, err := adbPair(context.Background(), server, "192.168.1.5:40001", "123456"); err != nil || !strings.Contains(output, "Successfully paired") {
+		t.Errorf("expected pairing to succeed, got %q (%v)", output, err)
+	}
+	if _, err := adbPair(context.Background(), server, "192.168.1.5:40001", "000000"); err == nil || !strings.Contains(err.Error(), "Wrong password") {
+		t.Errorf("expected a wrong pairing code to fail, got %v", err)
+	}
+
+	if output, err := adbConnect(context.Background(), server, "192.168.1.5:5555"); err != nil || output != "connected to 192.168.1.5:5555" {
+		t.Errorf("expected to connect, got %q (%v)", output, err)
+	}
+	if _, err := adbConnect(context.Background(), server, "192.168.1.99:5555"); err == nil || !strings.Contains(err.Error(), "Connection refused") {
+		t.Errorf("expected a refused connection to fail, got %v", err)
+	}
+
+	if output, err := adbDisconnect(context.Background(), server, "192.168.1.5:5555"); err != nil || output != "disconnected 192.168.1.5:5555" {
+		t.Errorf("expected to disconnect, got %q (%v)", output, err)
+	}
+	if _, err := adbDisconnect(context.Background(), server, "192.168.1.7:5555"); err == nil || !strings.Contains(err.Error(), "no such device") {
+		t.Errorf("expected an unknown device to fail, got %v", err)
+	}
+
+	if address, err := adbTCPIP(context.Background(), "emulator-5554", 5556); err != nil || address != "192.168.1.5:5556" {
+		t.Errorf("expected the Wi-Fi address, got %q (%v)", address, err)
+	}
+
+	services, err := discoverMDNSServices(context.Background(), server, mdnsConnectService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || services[0].Address != "192.168.1.5:37123" {
+		t.Errorf("expected the connect service only, got %+v", services)
+	}
+}
+
+func TestDisconnectPolicy(t *testing.T) {
+	useHelperDevices(t, "emulator-5554\tdevice", "192.168.1.5:5555\tdevice", "192.168.1.6:5555\tdevice")
+	useConfig(t, &Config{Policy: PolicyConfig{DeniedDevices: []string{"192.168.1.6:*"}}})
+
+	if err := checkDisconnectPolicy(context.Background(), adbServer{}, "192.168.1.5:5555"); err != nil {
+		t.Errorf("expected an allowed device to be disconnected, got %v", err)
+	}
+	if err := checkDisconnectPolicy(context.Background(), adbServer{}, "192.168.1.6:5555"); err == nil || !strings.Contains(err.Error(), "device 192.168.1.6:5555 is denied") {
+		t.Errorf("expected a denied device to be refused, got %v", err)
+	}
+	if err := checkDisconnectPolicy(context.Background(), adbServer{}, ""); err == nil || !strings.Contains(err.Error(), "device 192.168.1.6:5555 is denied") {
+		t.Errorf("expected disconnecting everything to be refused, got %v", err)
+	}
+
+	useConfig(t, &Config{Policy: PolicyConfig{DeniedDevices: []string{"emulator-*"}}})
+	if err := checkDisconnectPolicy(context.Background(), adbServer{}, ""); err != nil {
+		t.Errorf("expected only TCP devices to be checked, got %v", err)
+	}
+}